@@ -0,0 +1,121 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Amounts are stored and computed as integers of minor units (cents for
+// EUR). Clients send and receive decimal strings in major units.
+
+// most minor-unit digits a currency can have
+const maxDigits = 8
+
+// a decimal amount: digits, optionally followed by a point and decimals
+var decimalAmount = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+var errOverflow = newError(codeAmountOverflow, nil)
+
+// pow10 returns 10^n for 0 <= n <= maxDigits
+func pow10(n int) uint64 {
+	p := uint64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
+
+// parseAmount converts a decimal string into minor units. Amounts with more
+// decimals than the currency allows are rejected rather than rounded.
+func parseAmount(s string, digits int) (uint64, error) {
+	if !decimalAmount.MatchString(s) {
+		return 0, newError(codeInvalidAmount, details{"amount": s})
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if len(frac) > digits {
+		return 0, newError(codeTooManyDecimals, details{"amount": s, "digits": strconv.Itoa(digits)})
+	}
+	frac = frac + strings.Repeat("0", digits-len(frac))
+
+	major, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil || major > math.MaxUint64/pow10(digits) {
+		return 0, newError(codeAmountTooLarge, details{"amount": s})
+	}
+	minor := major * pow10(digits)
+
+	if frac != "" {
+		f, _ := strconv.ParseUint(frac, 10, 64)
+		if minor > math.MaxUint64-f {
+			return 0, newError(codeAmountTooLarge, details{"amount": s})
+		}
+		minor += f
+	}
+	return minor, nil
+}
+
+// addAmount returns a + b, or errOverflow when the sum does not fit in a uint64
+func addAmount(a uint64, b uint64) (uint64, error) {
+	if a > math.MaxUint64-b {
+		return 0, errOverflow
+	}
+	return a + b, nil
+}
+
+// subAmount returns a - b, or errOverflow when b is greater than a
+func subAmount(a uint64, b uint64) (uint64, error) {
+	if b > a {
+		return 0, errOverflow
+	}
+	return a - b, nil
+}
+
+// scaleAmount converts a whole amount in major units into minor units, or
+// returns errOverflow when it does not fit in a uint64
+func scaleAmount(major uint64, digits int) (uint64, error) {
+	if major > math.MaxUint64/pow10(digits) {
+		return 0, errOverflow
+	}
+	return major * pow10(digits), nil
+}
+
+// formatAmount converts minor units into a decimal string, e.g. 1250 -> 12.50
+func formatAmount(minor uint64, digits int) string {
+	if digits == 0 {
+		return strconv.FormatUint(minor, 10)
+	}
+	p := pow10(digits)
+	return fmt.Sprintf("%d.%0*d", minor/p, digits, minor%p)
+}
+
+// amountValue is an amount both formatted and in minor units
+type amountValue struct {
+	Amount string `json:"amount"` // decimal in major units, e.g. 12.50
+	Minor  string `json:"minor"`  // integer in minor units, e.g. 1250
+}
+
+func newAmountValue(minor uint64, digits int) amountValue {
+	return amountValue{Amount: formatAmount(minor, digits), Minor: strconv.FormatUint(minor, 10)}
+}
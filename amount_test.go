@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAmount_Parse(t *testing.T) {
+	valid := []struct {
+		s      string
+		digits int
+		minor  uint64
+	}{
+		{"12.50", 2, 1250},
+		{"12.5", 2, 1250},
+		{"12", 2, 1200},
+		{"0.01", 2, 1},
+		{"007", 0, 7},
+		{"1.234", 3, 1234},
+		{"184467440737095516.15", 2, 18446744073709551615},
+	}
+	for _, v := range valid {
+		minor, err := parseAmount(v.s, v.digits)
+		if err != nil || minor != v.minor {
+			fmt.Println("parseAmount", v.s, v.digits, "gave", minor, err, "instead of", v.minor)
+			t.FailNow()
+		}
+	}
+
+	invalid := []struct {
+		s      string
+		digits int
+	}{
+		{"12.505", 2}, // no rounding
+		{"12.5", 0},
+		{"-1", 2},
+		{"+1", 2},
+		{"1e3", 2},
+		{".5", 2},
+		{"5.", 2},
+		{"", 2},
+		{"1,50", 2},
+		{"184467440737095516.16", 2},
+		{"99999999999999999999", 0},
+	}
+	for _, v := range invalid {
+		if minor, err := parseAmount(v.s, v.digits); err == nil {
+			fmt.Println("parseAmount accepted", v.s, v.digits, "as", minor)
+			t.FailNow()
+		}
+	}
+}
+
+func TestAmount_Format(t *testing.T) {
+	tests := []struct {
+		minor  uint64
+		digits int
+		s      string
+	}{
+		{1250, 2, "12.50"},
+		{1, 2, "0.01"},
+		{0, 2, "0.00"},
+		{1234, 0, "1234"},
+		{1234, 3, "1.234"},
+	}
+	for _, test := range tests {
+		if s := formatAmount(test.minor, test.digits); s != test.s {
+			fmt.Println("formatAmount", test.minor, test.digits, "gave", s, "instead of", test.s)
+			t.FailNow()
+		}
+	}
+	if newAmountValue(1250, 2) != (amountValue{"12.50", "1250"}) {
+		fmt.Println("unexpected amountValue", newAmountValue(1250, 2))
+		t.FailNow()
+	}
+}
+
+func TestAmount_Move(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000000.00")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("5000000"), []byte("JPY"), []byte("0")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("issue"), []byte("5000000"), []byte("JPY"), []byte("2")})
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("5000000"), []byte("JPY"), []byte("")})
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("5000.50"), []byte("GBP"), []byte("")})
+
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("20.25")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("500"), []byte("JPY")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("0.01")})
+
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("12.505")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1.5"), []byte("JPY")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("12.5")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("150"), []byte("JPY")})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("query"), []byte("COMPTE_KARINE")})
+	if string(res.Payload) != `{"version":1,"name":"COMPTE_KARINE","currency":"EUR","balance":"12.51","balances":{"EUR":"12.51","JPY":"150"}}` {
+		fmt.Println("unexpected balances", string(res.Payload))
+		t.FailNow()
+	}
+
+	// a limit for any currency is in major units, a currency limit has the scale of the currency
+	checkInvokeFails(t, stub, [][]byte{[]byte("setlimit"), []byte("default"), []byte(""), []byte("daily"), []byte("10.5")})
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("default"), []byte(""), []byte("daily"), []byte("20")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("7.51")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("7.50")})
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("account"), []byte("COMPTE_JYG"), []byte("daily"), []byte("20.01"), []byte("EUR")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("0.01")})
+}
@@ -0,0 +1,558 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// A move above the approval threshold of its debit account does not execute:
+// its amount leaves the balance of the debit account for its held balance and
+// a pending transfer is written under the pending index, keyed by the TxID of
+// the move. Identities holding the approver role approve it with
+// approvetransfer, the approval completing the quorum executes it. A single
+// rejecttransfer releases the amount back to the balance. A pending transfer
+// can no longer be approved from its Expires day on, expiretransfers releases
+// the expired ones.
+const pendingIndex = "pending" // TxID
+
+// the approval policy is kept in world state so it can change without a redeploy
+const approvalsKey = "MPLBANK_APPROVALS"
+
+// values used when the ledger holds no approval policy yet
+const (
+	defaultQuorum = 2
+	defaultExpiry = 5 // business days
+)
+
+// status of a pending transfer
+const (
+	pendingStatus  = "PENDING"
+	approvedStatus = "APPROVED"
+	rejectedStatus = "REJECTED"
+	expiredStatus  = "EXPIRED"
+)
+
+type approvalPolicy struct {
+	ObjectType string `json:"docType"`
+	Quorum     int    `json:"quorum"` // distinct approvals executing a pending transfer
+	Expiry     int    `json:"expiry"` // business days a pending transfer waits for its quorum
+}
+
+type pendingTransfer struct {
+	ObjectType  string   `json:"docType"`
+	TxID        string   `json:"txid"` // TxID of the move, the transfer record keeps it once executed
+	Debit       string   `json:"debit"`
+	Credit      string   `json:"credit"`
+	Currency    string   `json:"currency"`
+	Amount      uint64   `json:"amount"` // minor units
+	Memo        string   `json:"memo,omitempty"`
+	Reference   string   `json:"reference,omitempty"`
+	Requester   string   `json:"requester"`   // MSP ID and common name of the caller of move
+	BusinessDay string   `json:"businessday"` // business day of the move
+	Expires     string   `json:"expires"`     // first business day it can no longer be approved
+	Status      string   `json:"status"`
+	Approvals   []string `json:"approvals"`           // MSP ID and common name of the approvers so far
+	DecidedBy   string   `json:"decidedby,omitempty"` // TxID of the transaction that executed or released it
+}
+
+// pendingResponse is the result of approvetransfer and rejecttransfer
+type pendingResponse struct {
+	Version     int      `json:"version"`
+	TxID        string   `json:"txid"`
+	Debit       string   `json:"debit"`
+	Credit      string   `json:"credit"`
+	Currency    string   `json:"currency"`
+	Amount      string   `json:"amount"`
+	Requester   string   `json:"requester"`
+	BusinessDay string   `json:"businessday"`
+	Expires     string   `json:"expires"`
+	Status      string   `json:"status"`
+	Approvals   []string `json:"approvals"`
+	Quorum      int      `json:"quorum"`
+}
+
+// expireResponse is the result of expiretransfers
+type expireResponse struct {
+	Version int `json:"version"`
+	Expired int `json:"expired"` // number of pending transfers released
+}
+
+func newApprovalPolicy() *approvalPolicy {
+	return &approvalPolicy{ObjectType: "APPROVALS", Quorum: defaultQuorum, Expiry: defaultExpiry}
+}
+
+func getApprovalPolicy(stub shim.ChaincodeStubInterface) (*approvalPolicy, error) {
+	policybytes, err := stub.GetState(approvalsKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", approvalsKey)
+	}
+	policy := newApprovalPolicy()
+	if policybytes == nil {
+		return policy, nil
+	}
+	err = json.Unmarshal(policybytes, policy)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", approvalsKey)
+	}
+	return policy, nil
+}
+
+func putApprovalPolicy(stub shim.ChaincodeStubInterface, policy *approvalPolicy) error {
+	policybytes, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(approvalsKey, policybytes)
+}
+
+// expires returns the first business day a transfer pending since day can no longer be approved
+func (p *approvalPolicy) expires(day string) string {
+	start, err := time.Parse(dayLayout, day)
+	if err != nil {
+		return day
+	}
+	return start.AddDate(0, 0, p.Expiry).Format(dayLayout)
+}
+
+// isExpired tells whether the transfer can no longer be approved on today, days sort as strings
+func (p *pendingTransfer) isExpired(today string) bool {
+	return today >= p.Expires
+}
+
+func getPendingTransfer(stub shim.ChaincodeStubInterface, txid string) (*pendingTransfer, error) {
+	key, err := stub.CreateCompositeKey(pendingIndex, []string{txid})
+	if err != nil {
+		return nil, err
+	}
+	pendingbytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for pending transfer %s", txid)
+	}
+	if pendingbytes == nil {
+		return nil, newError(codeTransferNotFound, details{"txid": txid})
+	}
+	p := &pendingTransfer{}
+	err = json.Unmarshal(pendingbytes, p)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of pending transfer %s", txid)
+	}
+	return p, nil
+}
+
+func putPendingTransfer(stub shim.ChaincodeStubInterface, p *pendingTransfer) error {
+	key, err := stub.CreateCompositeKey(pendingIndex, []string{p.TxID})
+	if err != nil {
+		return err
+	}
+	pendingbytes, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, pendingbytes)
+}
+
+// holdForApproval turns the move of the current transaction into a pending
+// transfer: the debit account, its balance and daily total already debited,
+// holds the amount until the transfer is approved or released
+func holdForApproval(stub shim.ChaincodeStubInterface, debit *account, p *pendingTransfer) error {
+	var err error
+	debit.Held[p.Currency], err = addAmount(debit.Held[p.Currency], p.Amount)
+	if err != nil {
+		return err
+	}
+	err = putAccount(stub, debit)
+	if err != nil {
+		return err
+	}
+	err = putPendingTransfer(stub, p)
+	if err != nil {
+		return err
+	}
+
+	// the reference is taken on the day of the move, a replay is rejected while the transfer waits
+	if p.Reference != "" {
+		referenceKey, err := stub.CreateCompositeKey(referenceIndex, []string{p.Debit, p.BusinessDay, p.Reference})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(referenceKey, []byte(p.TxID))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getPendingDebit reads the debit account of a pending transfer and folds its deltas
+func getPendingDebit(stub shim.ChaincodeStubInterface, p *pendingTransfer) (*account, error) {
+	debit, err := getAccount(stub, p.Debit)
+	if err != nil {
+		return nil, err
+	}
+	_, err = consolidate(stub, debit)
+	if err != nil {
+		return nil, err
+	}
+	return debit, nil
+}
+
+// unhold takes amount from the held balance of the account
+func (acc *account) unhold(currency string, amount uint64) error {
+	held, err := subAmount(acc.Held[currency], amount)
+	if err != nil {
+		return fmt.Errorf("Held balance of %s does not cover %d %s", acc.Name, amount, currency)
+	}
+	acc.Held[currency] = held
+	return nil
+}
+
+// refundDailyTotal gives amount back to the daily total of the account when it belongs to day
+func (acc *account) refundDailyTotal(day string, currency string, amount uint64) {
+	if acc.LastDebitDay != day {
+		return
+	}
+	total, err := subAmount(acc.TotalsForDay[currency], amount)
+	if err != nil {
+		total = 0
+	}
+	acc.TotalsForDay[currency] = total
+}
+
+// newTransferRelease describes the release of the pending transfer p, debit is its debit account once released
+func newTransferRelease(p *pendingTransfer, debit *account, currencies *currencies) transferRelease {
+	digits := currencies.digits(p.Currency)
+	return transferRelease{
+		Transfer:     p.TxID,
+		Debit:        p.Debit,
+		Credit:       p.Credit,
+		Currency:     p.Currency,
+		Amount:       newAmountValue(p.Amount, digits),
+		DebitBalance: newAmountValue(debit.Balances[p.Currency], digits),
+		Status:       p.Status,
+	}
+}
+
+// releasePending gives the amount of a pending transfer back to the balance
+// and the daily total of its debit account, the caller writes the account
+func releasePending(stub shim.ChaincodeStubInterface, debit *account, p *pendingTransfer, status string) error {
+	err := debit.unhold(p.Currency, p.Amount)
+	if err != nil {
+		return err
+	}
+	debit.Balances[p.Currency], err = addAmount(debit.Balances[p.Currency], p.Amount)
+	if err != nil {
+		return err
+	}
+	debit.refundDailyTotal(p.BusinessDay, p.Currency, p.Amount)
+
+	p.Status, p.DecidedBy = status, stub.GetTxID()
+	return putPendingTransfer(stub, p)
+}
+
+// checkApprover verifies the caller can decide on a pending transfer
+func checkApprover(p *pendingTransfer, caller *identity) error {
+	if p.Status != pendingStatus {
+		return newError(codeTransferNotPending, details{"txid": p.TxID, "status": p.Status})
+	}
+	if p.Requester == caller.key() {
+		return newError(codeOwnTransfer, details{"txid": p.TxID})
+	}
+	return nil
+}
+
+func newPendingResponse(p *pendingTransfer, c *currencies, quorum int) *pendingResponse {
+	return &pendingResponse{
+		Version:     responseVersion,
+		TxID:        p.TxID,
+		Debit:       p.Debit,
+		Credit:      p.Credit,
+		Currency:    p.Currency,
+		Amount:      c.format(p.Amount, p.Currency),
+		Requester:   p.Requester,
+		BusinessDay: p.BusinessDay,
+		Expires:     p.Expires,
+		Status:      p.Status,
+		Approvals:   p.Approvals,
+		Quorum:      quorum,
+	}
+}
+
+// approvetransfer adds the approval of the caller to a pending transfer,
+// args are the TxID of the move. The approval completing the quorum
+// executes the transfer.
+func (t *SimpleChaincode) approvetransfer(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	p, err := getPendingTransfer(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = checkApprover(p, caller)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if p.isExpired(today) {
+		return errorResponse(stub, newError(codeTransferExpired, details{"txid": p.TxID, "expires": p.Expires}))
+	}
+	for _, approver := range p.Approvals {
+		if approver == caller.key() {
+			return errorResponse(stub, newError(codeAlreadyApproved, details{"txid": p.TxID}))
+		}
+	}
+	p.Approvals = append(p.Approvals, caller.key())
+
+	policy, err := getApprovalPolicy(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if len(p.Approvals) < policy.Quorum {
+		err = putPendingTransfer(stub, p)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		return respond(stub, newPendingResponse(p, currencies, policy.Quorum))
+	}
+
+	// the quorum is reached, the held amount goes to the credit account
+	debit, err := getPendingDebit(stub, p)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = debit.unhold(p.Currency, p.Amount)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	credit, err := getAccount(stub, p.Credit)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if credit.isClosed() {
+		return errorResponse(stub, newError(codeAccountClosed, details{"account": credit.Name}))
+	}
+	creditBalance, err := addAmount(credit.Balances[p.Currency], p.Amount)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	credit.Balances[p.Currency] = creditBalance
+
+	err = putAccount(stub, debit)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if credit.Deltas {
+		err = putDelta(stub, credit.Name, map[string]uint64{p.Currency: p.Amount}, nil)
+	} else {
+		err = putAccount(stub, credit)
+	}
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	// the transfer record keeps the TxID, day and requester of the move
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	tr := newTransfer(stub, p.Debit, p.Credit, p.Currency, p.Amount, p.BusinessDay, now, caller)
+	tr.TxID, tr.Requester, tr.Memo, tr.Reference = p.TxID, p.Requester, p.Memo, p.Reference
+	err = putTransfer(stub, tr, now)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	p.Status, p.DecidedBy = approvedStatus, stub.GetTxID()
+	err = putPendingTransfer(stub, p)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	digits := currencies.digits(p.Currency)
+	err = setEvent(stub, eventTransfer, transferEvent{
+		eventHeader:   newEventHeader(stub, eventTransfer, today, caller),
+		Debit:         p.Debit,
+		Credit:        p.Credit,
+		Currency:      p.Currency,
+		Amount:        newAmountValue(p.Amount, digits),
+		DebitBalance:  newAmountValue(debit.Balances[p.Currency], digits),
+		CreditBalance: newAmountValue(creditBalance, digits),
+		Memo:          p.Memo,
+		Reference:     p.Reference,
+		Approved:      p.TxID,
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return respond(stub, newPendingResponse(p, currencies, policy.Quorum))
+}
+
+// rejecttransfer releases a pending transfer, args are the TxID of the move
+func (t *SimpleChaincode) rejecttransfer(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	p, err := getPendingTransfer(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = checkApprover(p, caller)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	policy, err := getApprovalPolicy(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	debit, err := getPendingDebit(stub, p)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = releasePending(stub, debit, p, rejectedStatus)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = putAccount(stub, debit)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = setEvent(stub, eventTransferReleased, transferReleasedEvent{
+		eventHeader:     newEventHeader(stub, eventTransferReleased, today, caller),
+		transferRelease: newTransferRelease(p, debit, currencies),
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return respond(stub, newPendingResponse(p, currencies, policy.Quorum))
+}
+
+// expiretransfers releases the pending transfers that can no longer be
+// approved. It emits no event, a transaction has a single one.
+func (t *SimpleChaincode) expiretransfers(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(pendingIndex, []string{})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	defer resultsIterator.Close()
+
+	expired := []*pendingTransfer{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		p := &pendingTransfer{}
+		err = json.Unmarshal(kv.Value, p)
+		if err != nil {
+			return errorResponse(stub, fmt.Errorf("Failed to decode JSON of: %s", kv.Key))
+		}
+		if p.Status == pendingStatus && p.isExpired(today) {
+			expired = append(expired, p)
+		}
+	}
+
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	// an account is read and written once, a transaction does not read its own writes
+	debits, names := map[string]*account{}, []string{}
+	released := []transferRelease{}
+	for _, p := range expired {
+		debit, read := debits[p.Debit]
+		if !read {
+			debit, err = getPendingDebit(stub, p)
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+			debits[p.Debit], names = debit, append(names, p.Debit)
+		}
+		err = releasePending(stub, debit, p, expiredStatus)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		released = append(released, newTransferRelease(p, debit, currencies))
+	}
+	for _, name := range names {
+		err = putAccount(stub, debits[name])
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+
+	if len(released) > 0 {
+		err = setEvent(stub, eventTransfersExpired, transfersExpiredEvent{
+			eventHeader: newEventHeader(stub, eventTransfersExpired, today, caller),
+			Transfers:   released,
+		})
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+	return respond(stub, &expireResponse{Version: responseVersion, Expired: len(expired)})
+}
+
+// setapprovalpolicy sets the number of approvals executing a pending transfer
+// and the number of business days it waits for them, args are both numbers
+func (t *SimpleChaincode) setapprovalpolicy(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	quorum, err := strconv.Atoi(args[0])
+	if err != nil || quorum < 1 {
+		return errorResponse(stub, newError(codeInvalidQuorum, details{"quorum": args[0]}))
+	}
+	expiry, err := strconv.Atoi(args[1])
+	if err != nil || expiry < 1 {
+		return errorResponse(stub, newError(codeInvalidExpiry, details{"expiry": args[1]}))
+	}
+
+	policy, err := getApprovalPolicy(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	policy.Quorum, policy.Expiry = quorum, expiry
+	err = putApprovalPolicy(stub, policy)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
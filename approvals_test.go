@@ -0,0 +1,178 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// checkDecision approves or rejects a pending transfer as Org1MSP/cn and verifies its status
+func checkDecision(t *testing.T, stub *shim.MockStub, cn string, function string, txid string, status string, approvals int) {
+	setCreator(t, stub, "Org1MSP", cn)
+	res := stub.MockInvoke("d-"+cn+"-"+txid, [][]byte{[]byte(function), []byte(txid)})
+	var resp pendingResponse
+	if err := json.Unmarshal(res.Payload, &resp); err != nil || resp.Status != status || len(resp.Approvals) != approvals {
+		fmt.Println(function, txid, "by", cn, "returned", string(res.Payload), res.Message, "instead of", status)
+		t.FailNow()
+	}
+}
+
+// checkHeld verifies the balance and the held balance of an account
+func checkHeld(t *testing.T, stub *shim.MockStub, name string, balance string, held string) {
+	res := stub.MockInvoke("1", [][]byte{[]byte("query"), []byte(name)})
+	var acc accountResponse
+	if err := json.Unmarshal(res.Payload, &acc); err != nil || acc.Balance != balance || acc.Held["EUR"] != held {
+		fmt.Println("account", name, "is", string(res.Payload), "instead of", balance, "held", held)
+		t.FailNow()
+	}
+}
+
+func TestApprovals_Quorum(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("default"), []byte(""), []byte("approval"), []byte("250")})
+	for _, cn := range []string{"alice", "bob", "jyg"} {
+		checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte(cn), []byte("approver")})
+	}
+
+	// below the threshold the move executes
+	checkMove(t, stub, "t3", "COMPTE_JYG", "COMPTE_KARINE", "200")
+	checkHeld(t, stub, "COMPTE_KARINE", "300.00", "")
+
+	res := stub.MockInvoke("p1", [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("300.50"), []byte(""), []byte("Achat voiture")})
+	if res.Status != shim.OK || string(res.Payload) != pendingStatus {
+		fmt.Println("move above the threshold returned", string(res.Payload), res.Message)
+		t.FailNow()
+	}
+	var pending transferPendingEvent
+	checkEvent(t, stub, eventTransferPending, &pending)
+	if pending.TxID != "p1" || pending.DebitBalance.Amount != "1499.50" || pending.Held.Amount != "300.50" || pending.Quorum != 2 || pending.Expires == "" {
+		fmt.Println("unexpected TransferPending", pending)
+		t.FailNow()
+	}
+	checkHeld(t, stub, "COMPTE_JYG", "1499.50", "300.50")
+	checkHeld(t, stub, "COMPTE_KARINE", "300.00", "")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+	checkError(t, stub, codeTransferNotFound, [][]byte{[]byte("gettransfer"), []byte("p1")})
+	checkError(t, stub, codeFundsHeld, [][]byte{[]byte("closeaccount"), []byte("COMPTE_JYG"), []byte("MPLBANK")})
+
+	// the requester cannot approve its own move, an approver only once
+	checkError(t, stub, codeOwnTransfer, [][]byte{[]byte("approvetransfer"), []byte("p1")})
+	checkDecision(t, stub, "alice", "approvetransfer", "p1", pendingStatus, 1)
+	checkError(t, stub, codeAlreadyApproved, [][]byte{[]byte("approvetransfer"), []byte("p1")})
+	checkDecision(t, stub, "bob", "approvetransfer", "p1", approvedStatus, 2)
+
+	var executed transferEvent
+	checkEvent(t, stub, eventTransfer, &executed)
+	if executed.Approved != "p1" || executed.DebitBalance.Amount != "1499.50" || executed.CreditBalance.Amount != "600.50" || executed.Memo != "Achat voiture" {
+		fmt.Println("unexpected Transfer", executed)
+		t.FailNow()
+	}
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkHeld(t, stub, "COMPTE_KARINE", "600.50", "")
+	checkHeld(t, stub, "COMPTE_JYG", "1499.50", "")
+	res = stub.MockInvoke("1", [][]byte{[]byte("gettransfer"), []byte("p1")})
+	var tr transferResponse
+	if err := json.Unmarshal(res.Payload, &tr); err != nil || tr.TxID != "p1" || tr.Requester != "Org1MSP/jyg" || tr.Amount != "300.50" {
+		fmt.Println("unexpected transfer record", string(res.Payload), res.Message)
+		t.FailNow()
+	}
+	checkError(t, stub, codeTransferNotPending, [][]byte{[]byte("rejecttransfer"), []byte("p1")})
+
+	// a single rejection releases the amount, and gives it back to the daily total
+	checkMove(t, stub, "p2", "COMPTE_JYG", "COMPTE_KARINE", "499.50")
+	checkError(t, stub, codeDailyLimitExceeded, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1")})
+	checkDecision(t, stub, "alice", "rejecttransfer", "p2", rejectedStatus, 0)
+	var released transferReleasedEvent
+	checkEvent(t, stub, eventTransferReleased, &released)
+	if released.Transfer != "p2" || released.DebitBalance.Amount != "1499.50" || released.Status != rejectedStatus {
+		fmt.Println("unexpected TransferReleased", released)
+		t.FailNow()
+	}
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkHeld(t, stub, "COMPTE_JYG", "1499.50", "")
+	checkMove(t, stub, "t4", "COMPTE_JYG", "COMPTE_KARINE", "1")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkError(t, stub, codeAccessDenied, [][]byte{[]byte("approvetransfer"), []byte("p1")})
+	checkError(t, stub, codeAccessDenied, [][]byte{[]byte("expiretransfers")})
+}
+
+func TestApprovals_Expiry(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("account"), []byte("COMPTE_JYG"), []byte("approval"), []byte("100")})
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte("alice"), []byte("approver")})
+
+	checkError(t, stub, codeInvalidQuorum, [][]byte{[]byte("setapprovalpolicy"), []byte("0"), []byte("2")})
+	checkError(t, stub, codeInvalidExpiry, [][]byte{[]byte("setapprovalpolicy"), []byte("1"), []byte("0")})
+	checkInvoke(t, stub, [][]byte{[]byte("setapprovalpolicy"), []byte("1"), []byte("2")})
+
+	// the threshold is the one of the debit account
+	checkMove(t, stub, "t3", "COMPTE_KARINE", "COMPTE_JYG", "50")
+	checkMove(t, stub, "p1", "COMPTE_JYG", "COMPTE_KARINE", "150")
+	checkMove(t, stub, "p2", "COMPTE_JYG", "COMPTE_KARINE", "250")
+	checkMove(t, stub, "p3", "COMPTE_JYG", "COMPTE_KARINE", "120")
+	checkHeld(t, stub, "COMPTE_JYG", "1530.00", "520.00")
+
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	checkDecision(t, stub, "alice", "approvetransfer", "p1", approvedStatus, 1)
+	setCreator(t, stub, "Org1MSP", "jyg")
+	res := stub.MockInvoke("1", [][]byte{[]byte("expiretransfers")})
+	if string(res.Payload) != `{"version":1,"expired":0}` {
+		fmt.Println("expiretransfers returned", string(res.Payload), res.Message)
+		t.FailNow()
+	}
+
+	// from the Expires day on the transfer can only be released
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	setCreator(t, stub, "Org1MSP", "alice")
+	checkError(t, stub, codeTransferExpired, [][]byte{[]byte("approvetransfer"), []byte("p2")})
+	setCreator(t, stub, "Org1MSP", "jyg")
+	res = stub.MockInvoke("1", [][]byte{[]byte("expiretransfers")})
+	if string(res.Payload) != `{"version":1,"expired":2}` {
+		fmt.Println("expiretransfers returned", string(res.Payload), res.Message)
+		t.FailNow()
+	}
+	var expired transfersExpiredEvent
+	checkEvent(t, stub, eventTransfersExpired, &expired)
+	if len(expired.Transfers) != 2 || expired.Transfers[1].DebitBalance.Amount != "1900.00" || expired.Transfers[1].Status != expiredStatus {
+		fmt.Println("unexpected TransfersExpired", expired)
+		t.FailNow()
+	}
+	checkHeld(t, stub, "COMPTE_JYG", "1900.00", "")
+	checkHeld(t, stub, "COMPTE_KARINE", "200.00", "")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+	setCreator(t, stub, "Org1MSP", "alice")
+	checkError(t, stub, codeTransferNotPending, [][]byte{[]byte("rejecttransfer"), []byte("p2")})
+}
@@ -0,0 +1,181 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// states of an account at a point in time besides OPEN and CLOSED
+const (
+	statusNotOpened = "NOT_OPENED" // the account did not exist yet
+	statusDeleted   = "DELETED"    // the key of the account had been deleted
+)
+
+// balanceAtResponse is the result of querybalanceat. Balance and Balances
+// are available balances, as query returns them. Booked and BookedBalances
+// add the amounts held, they are the balances of getstatement.
+type balanceAtResponse struct {
+	Version        int               `json:"version"`
+	Name           string            `json:"name"`
+	At             string            `json:"at"` // instant of the balance, RFC 3339
+	Status         string            `json:"status"`
+	TxID           string            `json:"txid,omitempty"` // transaction that set the balance, empty when the account was not opened yet
+	Currency       string            `json:"currency"`       // default currency of the bank
+	Balance        string            `json:"balance"`        // available balance in the default currency
+	Balances       map[string]string `json:"balances"`       // available balance per currency
+	Booked         string            `json:"booked"`         // booked balance in the default currency
+	BookedBalances map[string]string `json:"bookedbalances"` // booked balance per currency
+}
+
+// book sets the booked balances of the response from a version of the account
+func (resp *balanceAtResponse) book(acc *account, currencies *currencies) error {
+	booked := map[string]uint64{}
+	for code, amount := range acc.Balances {
+		booked[code] = amount
+	}
+	for code, amount := range acc.Held {
+		total, err := addAmount(booked[code], amount)
+		if err != nil {
+			return err
+		}
+		booked[code] = total
+	}
+	resp.Booked = currencies.format(booked[currencies.Default], currencies.Default)
+	resp.BookedBalances = map[string]string{}
+	for code, amount := range booked {
+		resp.BookedBalances[code] = currencies.format(amount, code)
+	}
+	return nil
+}
+
+// parseInstant reads the at argument of querybalanceat, a day stands for
+// the end of that business day
+func parseInstant(stub shim.ChaincodeStubInterface, at string) (time.Time, error) {
+	if _, err := time.Parse(dayLayout, at); err == nil {
+		cal, err := getCalendar(stub)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return cal.dayEnd(at)
+	}
+	ts, err := parseTime(at)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// versions written at the very instant are included
+	return ts.Add(time.Nanosecond), nil
+}
+
+// querybalanceat returns the balances of an account at the end of a business
+// day or at a timestamp, args are the account and a day, e.g. 2017-06-26, or
+// an RFC 3339 timestamp. Like gethistory, only the owner, the auditors and
+// the bank admins read them.
+func (t *SimpleChaincode) querybalanceat(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	end, err := parseInstant(stub, args[1])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	acc, err := getAccount(stub, args[0])
+	if isError(err, codeAccountNotFound) {
+		acc, err = &account{Name: args[0]}, nil
+	}
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if !caller.reads(acc) {
+		return errorResponse(stub, newError(codeNotOwner, details{"account": args[0]}))
+	}
+
+	err = checkNoDeltas(stub, args[0], "querybalanceat")
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	resultsIterator, err := stub.GetHistoryForKey(args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	defer resultsIterator.Close()
+
+	resp := &balanceAtResponse{
+		Version:        responseVersion,
+		Name:           args[0],
+		At:             end.Add(-time.Nanosecond).Format(time.RFC3339Nano),
+		Status:         statusNotOpened,
+		Currency:       currencies.Default,
+		Balance:        currencies.format(0, currencies.Default),
+		Balances:       map[string]string{},
+		Booked:         currencies.format(0, currencies.Default),
+		BookedBalances: map[string]string{},
+	}
+	versions := 0
+	for resultsIterator.HasNext() {
+		historicValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		versions++
+		if !versionTime(historicValue).Before(end) {
+			continue
+		}
+
+		resp.TxID = historicValue.TxId
+		resp.Balance = currencies.format(0, currencies.Default)
+		resp.Balances = map[string]string{}
+		resp.Booked = resp.Balance
+		resp.BookedBalances = map[string]string{}
+		if historicValue.IsDelete {
+			resp.Status = statusDeleted
+			continue
+		}
+
+		var acc account
+		err = json.Unmarshal(historicValue.Value, &acc)
+		if err != nil {
+			return errorResponse(stub, fmt.Errorf("Failed to decode JSON of: %s", args[0]))
+		}
+		err = acc.normalize(currencies)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		view := newAccountResponse(&acc, currencies, formatVerbose)
+		resp.Status = view.Status
+		resp.Balance = view.Balance
+		resp.Balances = view.Balances
+		err = resp.book(&acc, currencies)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+	if versions == 0 {
+		return errorResponse(stub, newError(codeAccountNotFound, details{"account": args[0]}))
+	}
+
+	return respond(stub, resp)
+}
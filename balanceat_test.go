@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkBalanceAt(t *testing.T, stub *historyStub, name string, at string, status string, txid string, balance string) {
+	res := stub.run("q", time.Now(), false, "querybalanceat", name, at)
+	var resp balanceAtResponse
+	if err := json.Unmarshal(res.Payload, &resp); err != nil || res.Status != shim.OK {
+		fmt.Println("querybalanceat", name, at, "failed", res.Message)
+		t.FailNow()
+	}
+	if resp.Status != status || resp.TxID != txid || resp.Balance != balance {
+		fmt.Println("querybalanceat", name, at, "returned", string(res.Payload), "instead of", status, txid, balance)
+		t.FailNow()
+	}
+}
+
+func TestBalanceAt_Versions(t *testing.T) {
+	stub := newHistoryStub(t)
+	day := time.Date(2017, 6, 26, 9, 0, 0, 0, time.UTC)
+
+	if res := stub.run("init", day, true, "init", "900000"); res.Status != shim.OK {
+		fmt.Println("Init failed", res.Message)
+		t.FailNow()
+	}
+	stub.checkRun(t, "t1", day.Add(time.Hour), "openaccount", "COMPTE_JYG", "Org1MSP", "jyg", productCurrent, "2000")
+	stub.checkRun(t, "t2", day.Add(23*time.Hour), "openaccount", "COMPTE_KARINE", "Org1MSP", "jyg", productCurrent, "100")
+	stub.checkRun(t, "t3", day.Add(25*time.Hour), "move", "COMPTE_JYG", "COMPTE_KARINE", "10.50")
+	stub.checkRun(t, "t4", day.AddDate(0, 0, 2), "closeaccount", "COMPTE_KARINE", "COMPTE_JYG")
+
+	res := stub.run("q", time.Now(), false, "querybalanceat", "COMPTE_JYG", "2017-06-26")
+	expected := `{"version":1,"name":"COMPTE_JYG","at":"2017-06-26T23:59:59.999999999Z","status":"OPEN","txid":"t1","currency":"EUR","balance":"2000.00","balances":{"EUR":"2000.00"},"booked":"2000.00","bookedbalances":{"EUR":"2000.00"}}`
+	if string(res.Payload) != expected {
+		fmt.Println("querybalanceat returned", string(res.Payload), "instead of", expected)
+		t.FailNow()
+	}
+
+	checkBalanceAt(t, stub, "COMPTE_JYG", "2017-06-25", statusNotOpened, "", "0.00")
+	checkBalanceAt(t, stub, "COMPTE_JYG", "2017-06-27T09:59:59Z", statusOpen, "t1", "2000.00")
+	checkBalanceAt(t, stub, "COMPTE_JYG", "2017-06-27T10:00:00Z", statusOpen, "t3", "1989.50")
+	checkBalanceAt(t, stub, "COMPTE_JYG", "2017-06-28", statusOpen, "t4", "2100.00")
+	checkBalanceAt(t, stub, "COMPTE_KARINE", "2017-06-28", statusClosed, "t4", "0.00")
+
+	// with a cut-off at 9 the day ends before t3
+	stub.checkRun(t, "cal", day.AddDate(0, 0, 3), "setcalendar", "UTC", "9")
+	checkBalanceAt(t, stub, "COMPTE_KARINE", "2017-06-27", statusOpen, "t2", "100.00")
+
+	// an account removed by hand
+	stub.MockTransactionStart("t5")
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: day.AddDate(0, 0, 3).Unix()}
+	stub.DelState("COMPTE_KARINE")
+	stub.MockTransactionEnd("t5")
+	checkBalanceAt(t, stub, "COMPTE_KARINE", "2017-06-30", statusDeleted, "t5", "0.00")
+	checkBalanceAt(t, stub, "COMPTE_KARINE", "2017-06-29", statusClosed, "t4", "0.00")
+
+	stub.checkRun(t, "t6", day.AddDate(0, 0, 3), "setdeltas", "MPLBANK", "true")
+	for code, args := range map[string][]string{
+		codeAccountNotFound: {"querybalanceat", "COMPTE_INCONNU", "2017-06-28"},
+		codeInvalidTime:     {"querybalanceat", "COMPTE_JYG", "yesterday"},
+		codeDeltaMode:       {"querybalanceat", "MPLBANK", "2017-06-28"},
+	} {
+		res := stub.run("q", time.Now(), false, args...)
+		var e chaincodeError
+		if json.Unmarshal(res.Payload, &e) != nil || e.Code != code {
+			fmt.Println("querybalanceat", args, "returned", res.Message, "instead of", code)
+			t.FailNow()
+		}
+	}
+}
+
+func TestBalanceAt_Booked(t *testing.T) {
+	stub := newHistoryStub(t)
+	day := time.Date(2017, 6, 26, 9, 0, 0, 0, time.UTC)
+
+	if res := stub.run("init", day, true, "init", "900000"); res.Status != shim.OK {
+		fmt.Println("Init failed", res.Message)
+		t.FailNow()
+	}
+	stub.checkRun(t, "t1", day, "openaccount", "COMPTE_JYG", "Org1MSP", "jyg", productCurrent, "2000")
+	stub.checkRun(t, "t2", day, "openaccount", "BOUTIQUE_1", "Org1MSP", "shop", productMerchant)
+	stub.checkRun(t, "h1", day.Add(time.Hour), "placehold", "COMPTE_JYG", "BOUTIQUE_1", "80")
+
+	// the available balance leaves the hold out, the booked one is the closing balance of the statement
+	res := stub.run("q", time.Now(), false, "querybalanceat", "COMPTE_JYG", "2017-06-26")
+	expected := `{"version":1,"name":"COMPTE_JYG","at":"2017-06-26T23:59:59.999999999Z","status":"OPEN","txid":"h1","currency":"EUR","balance":"1920.00","balances":{"EUR":"1920.00"},"booked":"2000.00","bookedbalances":{"EUR":"2000.00"}}`
+	if string(res.Payload) != expected {
+		fmt.Println("querybalanceat returned", string(res.Payload), "instead of", expected)
+		t.FailNow()
+	}
+	res = stub.run("q", time.Now(), false, "getstatement", "COMPTE_JYG", "2017-06-26", "2017-06-26")
+	var statement statementResponse
+	if err := json.Unmarshal(res.Payload, &statement); err != nil || statement.ClosingBalance != "2000.00" {
+		fmt.Println("getstatement returned", string(res.Payload), res.Message)
+		t.FailNow()
+	}
+
+	// only the owner, the auditors and the bank admins read the balances
+	stub.checkRun(t, "g1", day.Add(2*time.Hour), "grantrole", "Org1MSP", "karine", "customer")
+	setCreator(t, stub.MockStub, "Org1MSP", "karine")
+	res = stub.run("q", time.Now(), false, "querybalanceat", "COMPTE_JYG", "2017-06-26")
+	var e chaincodeError
+	if json.Unmarshal(res.Payload, &e) != nil || e.Code != codeNotOwner {
+		fmt.Println("querybalanceat by another customer returned", res.Message)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// the calendar decides which business day a transaction belongs to
+const calendarKey = "MPLBANK_CALENDAR"
+
+// layout of a business day, e.g. 2017-06-26
+const dayLayout = "2006-01-02"
+
+type calendar struct {
+	ObjectType string `json:"docType"`
+	TimeZone   string `json:"timezone"`   // IANA name of the bank time zone
+	CutOffHour int    `json:"cutoffhour"` // transactions from this hour on count for the next day, 0 means midnight
+	Offset     int    `json:"offset"`     // days added by changeday, for testing only
+}
+
+func newCalendar() *calendar {
+	return &calendar{ObjectType: "CALENDAR", TimeZone: "UTC"}
+}
+
+func getCalendar(stub shim.ChaincodeStubInterface) (*calendar, error) {
+	calendarbytes, err := stub.GetState(calendarKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", calendarKey)
+	}
+	cal := newCalendar()
+	if calendarbytes == nil {
+		return cal, nil
+	}
+	err = json.Unmarshal(calendarbytes, cal)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", calendarKey)
+	}
+	return cal, nil
+}
+
+func putCalendar(stub shim.ChaincodeStubInterface, cal *calendar) error {
+	calendarbytes, err := json.Marshal(cal)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(calendarKey, calendarbytes)
+}
+
+// businessDay returns the business day of the instant ts
+func (c *calendar) businessDay(ts time.Time) (string, error) {
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return "", newError(codeUnknownTimeZone, details{"timezone": c.TimeZone})
+	}
+	local := ts.In(loc)
+	if c.CutOffHour > 0 && local.Hour() >= c.CutOffHour {
+		local = local.AddDate(0, 0, 1)
+	}
+	return local.AddDate(0, 0, c.Offset).Format(dayLayout), nil
+}
+
+// dayStart returns the instant a business day starts in the bank time zone,
+// when the day before ends
+func (c *calendar) dayStart(day string) (time.Time, error) {
+	start, err := time.Parse(dayLayout, day)
+	if err != nil {
+		return time.Time{}, newError(codeInvalidDay, details{"day": day})
+	}
+	return c.dayEnd(start.AddDate(0, 0, -1).Format(dayLayout))
+}
+
+// dayEnd returns the instant a business day ends in the bank time zone, at
+// the cut-off hour of that day or at the next midnight. Like businessDay it
+// applies the offset of changeday, the day ends that many days earlier.
+func (c *calendar) dayEnd(day string) (time.Time, error) {
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return time.Time{}, newError(codeUnknownTimeZone, details{"timezone": c.TimeZone})
+	}
+	start, err := time.ParseInLocation(dayLayout, day, loc)
+	if err != nil {
+		return time.Time{}, newError(codeInvalidDay, details{"day": day})
+	}
+	start = start.AddDate(0, 0, -c.Offset)
+	if c.CutOffHour > 0 {
+		return time.Date(start.Year(), start.Month(), start.Day(), c.CutOffHour, 0, 0, 0, loc).UTC(), nil
+	}
+	return start.AddDate(0, 0, 1).UTC(), nil
+}
+
+// txTime returns the timestamp of the current transaction
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to get transaction timestamp")
+	}
+	if ts == nil {
+		return time.Time{}, fmt.Errorf("Transaction has no timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// parseTime validates an optional RFC 3339 timestamp argument, zero when it is missing
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	ts, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, newError(codeInvalidTime, details{"time": s})
+	}
+	return ts.UTC(), nil
+}
+
+// currentBusinessDay returns the business day of the current transaction
+func currentBusinessDay(stub shim.ChaincodeStubInterface) (string, error) {
+	cal, err := getCalendar(stub)
+	if err != nil {
+		return "", err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return cal.businessDay(now)
+}
+
+// Moves the bank to the next business day, kept to test daily limits
+func (t *SimpleChaincode) changeday(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+	cal, err := getCalendar(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	previous, err := cal.businessDay(now)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	cal.Offset++
+
+	err = putCalendar(stub, cal)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	// GetState does not see the write above, so use the updated calendar directly
+	day, err := cal.businessDay(now)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = setEvent(stub, eventDayChanged, dayChangedEvent{
+		eventHeader: newEventHeader(stub, eventDayChanged, day, caller),
+		PreviousDay: previous,
+		Offset:      cal.Offset,
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success([]byte(day))
+}
+
+// setcalendar changes the time zone and the cut-off hour of the business day
+func (t *SimpleChaincode) setcalendar(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+	cal, err := getCalendar(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	_, err = time.LoadLocation(args[0])
+	if err != nil {
+		return errorResponse(stub, newError(codeUnknownTimeZone, details{"timezone": args[0]}))
+	}
+	hour, err := strconv.Atoi(args[1])
+	if err != nil || hour > 23 {
+		return errorResponse(stub, newError(codeInvalidCutOffHour, details{"cutoffhour": args[1]}))
+	}
+
+	cal.TimeZone = args[0]
+	cal.CutOffHour = hour
+
+	err = putCalendar(stub, cal)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
@@ -0,0 +1,102 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestCalendar_BusinessDay(t *testing.T) {
+	cal := &calendar{TimeZone: "Europe/Paris", CutOffHour: 17}
+
+	tests := []struct {
+		ts  string
+		day string
+	}{
+		{"2017-06-26T14:59:00Z", "2017-06-26"}, // 16:59 in Paris
+		{"2017-06-26T15:00:00Z", "2017-06-27"}, // 17:00 in Paris, after the cut-off
+		{"2017-06-26T22:30:00Z", "2017-06-27"}, // already the 27th in Paris
+	}
+	for _, test := range tests {
+		ts, _ := time.Parse(time.RFC3339, test.ts)
+		day, err := cal.businessDay(ts)
+		if err != nil || day != test.day {
+			fmt.Println("business day of", test.ts, "was", day, "instead of", test.day, err)
+			t.FailNow()
+		}
+	}
+
+	cal.Offset = 2
+	ts, _ := time.Parse(time.RFC3339, "2017-06-26T10:00:00Z")
+	if day, _ := cal.businessDay(ts); day != "2017-06-28" {
+		fmt.Println("offset not applied, got", day)
+		t.FailNow()
+	}
+}
+
+func TestCalendar_ChangeDay(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("100")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("900")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("200")})
+
+	// only the bank owner may skip a day
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkInvokeFails(t, stub, [][]byte{[]byte("changeday")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("setcalendar"), []byte("Europe/Paris"), []byte("17")})
+
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("200")})
+
+	checkInvokeFails(t, stub, [][]byte{[]byte("setcalendar"), []byte("Mars/Olympus"), []byte("17")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("setcalendar"), []byte("Europe/Paris"), []byte("24")})
+	checkInvoke(t, stub, [][]byte{[]byte("setcalendar"), []byte("Europe/Paris"), []byte("17")})
+}
+
+func TestCalendar_ChangeDayPeriods(t *testing.T) {
+	stub := newHistoryStub(t)
+	day := time.Date(2017, 6, 26, 9, 0, 0, 0, time.UTC)
+
+	if res := stub.run("init", day, true, "init", "900000"); res.Status != shim.OK {
+		fmt.Println("Init failed", res.Message)
+		t.FailNow()
+	}
+	// after changeday the transactions of June 26th belong to the 27th
+	stub.checkRun(t, "d1", day, "changeday")
+	stub.checkRun(t, "t1", day.Add(time.Hour), "openaccount", "COMPTE_JYG", "Org1MSP", "jyg", productCurrent, "2000")
+	stub.checkRun(t, "t2", day.AddDate(0, 0, 1), "openaccount", "COMPTE_KARINE", "Org1MSP", "jyg", productCurrent)
+	stub.checkRun(t, "t3", day.AddDate(0, 0, 1).Add(time.Hour), "move", "COMPTE_JYG", "COMPTE_KARINE", "10")
+
+	checkStatement(t, stub, `{"version":1,"name":"COMPTE_JYG","currency":"EUR","from":"2017-06-27","to":"2017-06-27",`+
+		`"openingbalance":"0.00","lines":[`+
+		`{"txid":"t1","timestamp":"2017-06-26T10:00:00Z","type":"credit","amount":"2000.00","balance":"2000.00","counterparty":"MPLBANK"}],`+
+		`"totalcredits":"2000.00","totaldebits":"0.00","closingbalance":"2000.00"}`,
+		"COMPTE_JYG", "2017-06-27", "2017-06-27")
+	checkBalanceAt(t, stub, "COMPTE_JYG", "2017-06-26", statusNotOpened, "", "0.00")
+	checkBalanceAt(t, stub, "COMPTE_JYG", "2017-06-27", statusOpen, "t1", "2000.00")
+	checkBalanceAt(t, stub, "COMPTE_JYG", "2017-06-28", statusOpen, "t3", "1990.00")
+}
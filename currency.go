@@ -0,0 +1,208 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// the currencies the bank deals in
+const currenciesKey = "MPLBANK_CURRENCIES"
+
+// currency of a bank initialized without one
+const defaultCurrency = "EUR"
+
+// minor-unit digits of a currency registered without any
+const defaultDigits = 2
+
+var currencyCode = regexp.MustCompile("^[A-Z]{3}$")
+
+type currencies struct {
+	ObjectType string         `json:"docType"`
+	Default    string         `json:"default"` // currency of moves without one and of legacy balances
+	Codes      []string       `json:"codes"`
+	Digits     map[string]int `json:"digits"` // minor-unit digits per currency
+}
+
+func newCurrencies(def string, digits int) *currencies {
+	return &currencies{ObjectType: "CURRENCIES", Default: def, Codes: []string{def}, Digits: map[string]int{def: digits}}
+}
+
+func getCurrencies(stub shim.ChaincodeStubInterface) (*currencies, error) {
+	currenciesbytes, err := stub.GetState(currenciesKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", currenciesKey)
+	}
+	if currenciesbytes == nil {
+		return newCurrencies(defaultCurrency, defaultDigits), nil
+	}
+	c := &currencies{}
+	err = json.Unmarshal(currenciesbytes, c)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", currenciesKey)
+	}
+	if c.Digits == nil {
+		c.Digits = map[string]int{}
+	}
+	return c, nil
+}
+
+func putCurrencies(stub shim.ChaincodeStubInterface, c *currencies) error {
+	currenciesbytes, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(currenciesKey, currenciesbytes)
+}
+
+func (c *currencies) has(code string) bool {
+	for _, known := range c.Codes {
+		if known == code {
+			return true
+		}
+	}
+	return false
+}
+
+// digits returns the minor-unit digits of a currency. Currencies registered
+// before amounts had decimals have none, so their stored integers keep their
+// meaning. Ledgers without a currency record default to defaultDigits, normalize
+// scales their legacy balances.
+func (c *currencies) digits(code string) int {
+	return c.Digits[code]
+}
+
+// parse converts a decimal amount of the currency into minor units
+func (c *currencies) parse(amount string, code string) (uint64, error) {
+	return parseAmount(amount, c.digits(code))
+}
+
+// format converts minor units of the currency into a decimal amount
+func (c *currencies) format(minor uint64, code string) string {
+	return formatAmount(minor, c.digits(code))
+}
+
+// value returns minor units of the currency both formatted and raw
+func (c *currencies) value(minor uint64, code string) amountValue {
+	return newAmountValue(minor, c.digits(code))
+}
+
+// resolve returns the currency named by an optional argument
+func (c *currencies) resolve(code string) (string, error) {
+	if code == "" {
+		return c.Default, nil
+	}
+	if !c.has(code) {
+		return "", newError(codeUnknownCurrency, details{"currency": code})
+	}
+	return code, nil
+}
+
+// issue credits the bank reserve with new money, registering the currency if
+// needed, and adds it to the supply. The minor-unit digits can only be given when the currency is registered.
+func (t *SimpleChaincode) issue(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+	code := args[1]
+	if !currencyCode.MatchString(code) {
+		return errorResponse(stub, newError(codeInvalidCurrency, details{"currency": code}))
+	}
+
+	c, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	given := len(args) > 2 && args[2] != ""
+	if !c.has(code) {
+		digits := defaultDigits
+		if given {
+			digits, err = strconv.Atoi(args[2])
+			if err != nil || digits > maxDigits {
+				return errorResponse(stub, newError(codeInvalidDigits, details{"digits": args[2], "max": strconv.Itoa(maxDigits)}))
+			}
+		}
+		c.Codes = append(c.Codes, code)
+		sort.Strings(c.Codes)
+		c.Digits[code] = digits
+		err = putCurrencies(stub, c)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	} else if given && args[2] != strconv.Itoa(c.digits(code)) {
+		return errorResponse(stub, newError(codeDigitsFixed, details{"currency": code, "digits": strconv.Itoa(c.digits(code))}))
+	}
+
+	amount, err := c.parse(args[0], code)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	// the reserve is debited against its base, new money goes there rather than
+	// into a delta, before the deltas are folded: it may cover debits above the base
+	bank, err := getAccount(stub, "MPLBANK")
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	bank.Balances[code], err = addAmount(bank.Balances[code], amount)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	_, err = consolidate(stub, bank)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	s, err := getSupply(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = s.mint(code, amount)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = putAccount(stub, bank)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = putSupply(stub, s)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	digits := c.digits(code)
+	err = setEvent(stub, eventIssued, issuedEvent{
+		eventHeader: newEventHeader(stub, eventIssued, today, caller),
+		Currency:    code,
+		Amount:      newAmountValue(amount, digits),
+		Reserve:     newAmountValue(bank.Balances[code], digits),
+		Supply:      newAmountValue(s.Totals[code], digits),
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
@@ -0,0 +1,281 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Every opening debits the reserve, so openings in the same block used to
+// conflict on the MPLBANK key and all but one failed. An account in delta
+// mode is not written by the transactions changing its balance: each of
+// them appends a delta record under the balance~delta index instead, keyed
+// by its TxID. The balance of the account is the base held by its record
+// plus its deltas, consolidatereserve folds the deltas into the base. No
+// account starts in delta mode, not even the reserve: a bank admin turns it
+// on with setdeltas, for instance setdeltas MPLBANK true before a wave of
+// openings.
+//
+// Neither credits nor debits of the reserve read its deltas, so they do not
+// conflict with each other: each debit is checked against the base alone.
+// Debits recorded since the last consolidation can then add up to more than
+// the base: the balance of the reserve cannot be read and consolidatereserve
+// refuses to fold them until issue covers the difference, it credits the new
+// money before folding. Consolidate the reserve well before the openings and
+// top-ups of a period, bounded by their limits, can exceed its base. Debits
+// of any other account, such as a merchant receiving many payments, fold its
+// deltas first and write its record.
+// History, statements and past balances follow the account record, they are
+// refused for an account in delta mode. For the periods an account was in it,
+// they show each consolidation as a single change without counterparty.
+const deltaIndex = "balance~delta" // account, TxID
+
+// balanceDelta holds what one transaction added to and took from an account, in minor units
+type balanceDelta struct {
+	ObjectType string            `json:"docType"`
+	Account    string            `json:"account"`
+	TxID       string            `json:"txid"`
+	Credits    map[string]uint64 `json:"credits,omitempty"`
+	Debits     map[string]uint64 `json:"debits,omitempty"`
+}
+
+// consolidateResponse is the result of consolidatereserve
+type consolidateResponse struct {
+	Version  int               `json:"version"`
+	Name     string            `json:"name"`
+	Deltas   int               `json:"deltas"`   // number of delta records folded
+	Balances map[string]string `json:"balances"` // balance per currency after consolidation
+}
+
+// checkNoDeltas refuses the views built from the history of an account in
+// delta mode, an account that does not exist, or no longer, is left to them
+func checkNoDeltas(stub shim.ChaincodeStubInterface, name string, function string) error {
+	accountbytes, err := stub.GetState(name)
+	if err != nil {
+		return fmt.Errorf("Failed to get state for %s", name)
+	}
+	if accountbytes == nil {
+		return nil
+	}
+	var acc account
+	err = json.Unmarshal(accountbytes, &acc)
+	if err != nil {
+		return fmt.Errorf("Failed to decode JSON of: %s", name)
+	}
+	if acc.Deltas {
+		return newError(codeDeltaMode, details{"function": function, "account": name})
+	}
+	return nil
+}
+
+// putDelta appends the delta of the current transaction to an account
+func putDelta(stub shim.ChaincodeStubInterface, name string, credits map[string]uint64, debits map[string]uint64) error {
+	d := &balanceDelta{ObjectType: "DELTA", Account: name, TxID: stub.GetTxID(), Credits: credits, Debits: debits}
+	key, err := stub.CreateCompositeKey(deltaIndex, []string{name, d.TxID})
+	if err != nil {
+		return err
+	}
+	deltabytes, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, deltabytes)
+}
+
+// debitReserve takes amount from the reserve, as a delta when it is in delta
+// mode, and returns its balance after the debit, the base less the amount
+func debitReserve(stub shim.ChaincodeStubInterface, bank *account, currency string, amount uint64, requested string) (uint64, error) {
+	balance, err := subAmount(bank.Balances[currency], amount)
+	if err != nil {
+		return 0, newError(codeInsufficientFunds, details{"account": bank.Name, "amount": requested, "currency": currency})
+	}
+	bank.Balances[currency] = balance
+	if bank.Deltas {
+		err = putDelta(stub, bank.Name, nil, map[string]uint64{currency: amount})
+	} else {
+		err = putAccount(stub, bank)
+	}
+	return balance, err
+}
+
+// applyDeltas adds the deltas of an account to its balances and returns the
+// keys of the delta records
+func applyDeltas(stub shim.ChaincodeStubInterface, acc *account) ([]string, error) {
+	keys := []string{}
+	if !acc.Deltas {
+		return keys, nil
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(deltaIndex, []string{acc.Name})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	debits := map[string]uint64{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		d := &balanceDelta{}
+		err = json.Unmarshal(kv.Value, d)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode JSON of: %s", kv.Key)
+		}
+		for currency, amount := range d.Credits {
+			acc.Balances[currency], err = addAmount(acc.Balances[currency], amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for currency, amount := range d.Debits {
+			debits[currency], err = addAmount(debits[currency], amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, kv.Key)
+	}
+
+	// debits are subtracted last, credits recorded after them may be what covers them
+	for currency, amount := range debits {
+		acc.Balances[currency], err = subAmount(acc.Balances[currency], amount)
+		if err != nil {
+			return nil, newError(codeInsufficientFunds, details{"account": acc.Name, "currency": currency})
+		}
+	}
+	return keys, nil
+}
+
+// settle sets the balances of an account read for display to its base plus its deltas
+func settle(stub shim.ChaincodeStubInterface, acc *account) error {
+	_, err := applyDeltas(stub, acc)
+	return err
+}
+
+// consolidate folds the deltas of an account into its balances and deletes
+// them, the caller writes the account
+func consolidate(stub shim.ChaincodeStubInterface, acc *account) (int, error) {
+	keys, err := applyDeltas(stub, acc)
+	if err != nil {
+		return 0, err
+	}
+	for _, key := range keys {
+		err = stub.DelState(key)
+		if err != nil {
+			return 0, fmt.Errorf("Failed to delete delta %s", key)
+		}
+	}
+	return len(keys), nil
+}
+
+// consolidatereserve folds the deltas of the reserve, or of the account given
+// in args, into the balances of its record
+func (t *SimpleChaincode) consolidatereserve(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	name := optional(args, 0)
+	if name == "" {
+		name = "MPLBANK"
+	}
+	acc, err := getAccount(stub, name)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	n, err := consolidate(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if n > 0 {
+		err = putAccount(stub, acc)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		err = setConsolidatedEvent(stub, acc, n, currencies, caller)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+
+	view := newAccountResponse(acc, currencies, formatCompact)
+	return respond(stub, &consolidateResponse{Version: responseVersion, Name: acc.Name, Deltas: n, Balances: view.Balances})
+}
+
+// setdeltas turns the delta mode of an account on or off, args are the
+// account and true or false. The deltas are consolidated either way.
+func (t *SimpleChaincode) setdeltas(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	enabled, err := strconv.ParseBool(args[1])
+	if err != nil {
+		return errorResponse(stub, newError(codeInvalidArgument, details{"function": "setdeltas", "argument": "enabled", "type": "boolean"}))
+	}
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if acc.isClosed() {
+		return errorResponse(stub, newError(codeAccountClosed, details{"account": acc.Name}))
+	}
+
+	n, err := consolidate(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	acc.Deltas = enabled
+	err = putAccount(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if n > 0 {
+		currencies, err := getCurrencies(stub)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		err = setConsolidatedEvent(stub, acc, n, currencies, caller)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+	return shim.Success(nil)
+}
+
+// setConsolidatedEvent tells that n deltas were folded into the record of acc
+func setConsolidatedEvent(stub shim.ChaincodeStubInterface, acc *account, n int, currencies *currencies, caller *identity) error {
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return err
+	}
+	balances := map[string]amountValue{}
+	for code, balance := range acc.Balances {
+		balances[code] = newAmountValue(balance, currencies.digits(code))
+	}
+	return setEvent(stub, eventConsolidated, consolidatedEvent{
+		eventHeader: newEventHeader(stub, eventConsolidated, today, caller),
+		Account:     acc.Name,
+		Deltas:      n,
+		Balances:    balances,
+	})
+}
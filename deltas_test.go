@@ -0,0 +1,127 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkConsolidate(t *testing.T, stub *shim.MockStub, name string, expected string) {
+	res := stub.MockInvoke("1", [][]byte{[]byte("consolidatereserve"), []byte(name)})
+	if string(res.Payload) != expected {
+		fmt.Println("consolidatereserve returned", string(res.Payload), res.Message, "instead of", expected)
+		t.FailNow()
+	}
+}
+
+// checkBase verifies the balance held by the record of an account, without its deltas
+func checkBase(t *testing.T, stub *shim.MockStub, name string, currency string, expected uint64) {
+	var acc account
+	if err := json.Unmarshal(stub.State[name], &acc); err != nil || acc.Balances[currency] != expected {
+		fmt.Println("base of", name, "is", string(stub.State[name]), "instead of", expected)
+		t.FailNow()
+	}
+}
+
+func TestDeltas_Reserve(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	checkInvoke(t, stub, [][]byte{[]byte("setdeltas"), []byte("MPLBANK"), []byte("true")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE", "COMPTE_LUC", "COMPTE_MAX")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+
+	// the openings did not write the reserve
+	checkBase(t, stub, "MPLBANK", "EUR", 90000000)
+	checkBalance(t, stub, "MPLBANK", "897900.00")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+
+	checkConsolidate(t, stub, "", `{"version":1,"name":"MPLBANK","deltas":2,"balances":{"EUR":"897900.00"}}`)
+	var consolidated consolidatedEvent
+	checkEvent(t, stub, eventConsolidated, &consolidated)
+	if consolidated.Account != "MPLBANK" || consolidated.Deltas != 2 || consolidated.Balances["EUR"] != (amountValue{"897900.00", "89790000"}) {
+		fmt.Println("unexpected Consolidated", consolidated)
+		t.FailNow()
+	}
+	checkBase(t, stub, "MPLBANK", "EUR", 89790000)
+	checkConsolidate(t, stub, "MPLBANK", `{"version":1,"name":"MPLBANK","deltas":0,"balances":{"EUR":"897900.00"}}`)
+
+	// issuing folds the deltas first, new money is at once available to openings
+	checkMove(t, stub, "t3", "MPLBANK", "COMPTE_LUC", "10")
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("5000"), []byte("USD")})
+	checkBase(t, stub, "MPLBANK", "EUR", 89789000)
+	checkMove(t, stub, "t4", "MPLBANK", "COMPTE_LUC", "30", "USD")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"},{"currency":"USD","supply":"5000.00","accounts":"5000.00","drift":"0.00"}]}`)
+
+	// the reserve is debited against its base, the debits not consolidated yet do not count
+	checkError(t, stub, codeInsufficientFunds, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_MAX"), []byte("5001"), []byte("USD")})
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("1000"), []byte("GBP")})
+	checkMove(t, stub, "t5", "MPLBANK", "COMPTE_JYG", "600", "GBP")
+	checkMove(t, stub, "t6", "MPLBANK", "COMPTE_KARINE", "600", "GBP")
+	checkError(t, stub, codeInsufficientFunds, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_LUC"), []byte("1000.01"), []byte("GBP")})
+
+	// together they exceed the base, they are folded once new money covers them
+	checkError(t, stub, codeInsufficientFunds, [][]byte{[]byte("consolidatereserve")})
+	checkBase(t, stub, "MPLBANK", "GBP", 100000)
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("200"), []byte("GBP")})
+	checkBalance(t, stub, "MPLBANK", "897890.00")
+	checkConsolidate(t, stub, "", `{"version":1,"name":"MPLBANK","deltas":0,"balances":{"EUR":"897890.00","GBP":"0.00","USD":"4970.00"}}`)
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"},{"currency":"GBP","supply":"1200.00","accounts":"1200.00","drift":"0.00"},{"currency":"USD","supply":"5000.00","accounts":"5000.00","drift":"0.00"}]}`)
+}
+
+func TestDeltas_Merchant(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	checkInvoke(t, stub, [][]byte{[]byte("setdeltas"), []byte("MPLBANK"), []byte("true")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+
+	checkError(t, stub, codeInvalidArgument, [][]byte{[]byte("setdeltas"), []byte("COMPTE_KARINE"), []byte("maybe")})
+	checkInvoke(t, stub, [][]byte{[]byte("setdeltas"), []byte("COMPTE_KARINE"), []byte("true")})
+
+	// payments to the merchant are deltas
+	checkMove(t, stub, "t3", "COMPTE_JYG", "COMPTE_KARINE", "10")
+	checkMove(t, stub, "t4", "COMPTE_JYG", "COMPTE_KARINE", "20")
+	checkBase(t, stub, "COMPTE_KARINE", "EUR", 10000)
+	checkBalance(t, stub, "COMPTE_KARINE", "130.00")
+
+	// a debit folds them
+	checkMove(t, stub, "t5", "COMPTE_KARINE", "COMPTE_JYG", "120")
+	checkBase(t, stub, "COMPTE_KARINE", "EUR", 1000)
+	checkConsolidate(t, stub, "COMPTE_KARINE", `{"version":1,"name":"COMPTE_KARINE","deltas":0,"balances":{"EUR":"10.00"}}`)
+
+	// closing folds them too, and sweeping into the reserve is a delta of the reserve
+	checkMove(t, stub, "t6", "COMPTE_JYG", "COMPTE_KARINE", "5")
+	checkInvoke(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_KARINE"), []byte("MPLBANK")})
+	checkBase(t, stub, "COMPTE_KARINE", "EUR", 0)
+	checkBalance(t, stub, "MPLBANK", "897915.00")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+
+	checkInvoke(t, stub, [][]byte{[]byte("setdeltas"), []byte("MPLBANK"), []byte("false")})
+	checkBase(t, stub, "MPLBANK", "EUR", 89791500)
+	checkError(t, stub, codeAccountClosed, [][]byte{[]byte("setdeltas"), []byte("COMPTE_KARINE"), []byte("true")})
+}
@@ -0,0 +1,326 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Error codes are part of the API, clients match on them: never rename or
+// reuse one. Messages can change and are localized, see messages below.
+const (
+	codeInternal             = "INTERNAL_ERROR" // ledger access or decoding failure, details in the detail field
+	codeUnknownFunction      = "UNKNOWN_FUNCTION"
+	codeWrongArgumentCount   = "WRONG_ARGUMENT_COUNT"
+	codeInvalidArgument      = "INVALID_ARGUMENT"
+	codeInvalidFormat        = "INVALID_FORMAT"
+	codeInvalidPageSize      = "INVALID_PAGE_SIZE"
+	codeInvalidOrder         = "INVALID_ORDER"
+	codeInvalidTime          = "INVALID_TIME"
+	codeInvalidDay           = "INVALID_DAY"
+	codeInvalidPeriod        = "INVALID_PERIOD"
+	codeInvalidFilter        = "INVALID_FILTER"
+	codeInvalidSelector      = "INVALID_SELECTOR"
+	codeInvalidAmount        = "INVALID_AMOUNT"
+	codeTooManyDecimals      = "TOO_MANY_DECIMALS"
+	codeAmountTooLarge       = "AMOUNT_TOO_LARGE"
+	codeAmountOverflow       = "AMOUNT_OVERFLOW"
+	codeEmptyReserve         = "EMPTY_RESERVE"
+	codeInvalidIdentity      = "INVALID_IDENTITY"
+	codeAccessDenied         = "ACCESS_DENIED"
+	codeNotOwner             = "NOT_OWNER"
+	codeAccountNotFound      = "ACCOUNT_NOT_FOUND"
+	codeNotAnAccount         = "NOT_AN_ACCOUNT"
+	codeInvalidAccountName   = "INVALID_ACCOUNT_NAME"
+	codeReservedAccountName  = "RESERVED_ACCOUNT_NAME"
+	codeAccountExists        = "ACCOUNT_EXISTS"
+	codeInvalidOwner         = "INVALID_OWNER"
+	codeUnknownProduct       = "UNKNOWN_PRODUCT"
+	codeTransferNotFound     = "TRANSFER_NOT_FOUND"
+	codeTransferNotPending   = "TRANSFER_NOT_PENDING"
+	codeTransferExpired      = "TRANSFER_EXPIRED"
+	codeOwnTransfer          = "OWN_TRANSFER"
+	codeAlreadyApproved      = "ALREADY_APPROVED"
+	codeFundsHeld            = "FUNDS_HELD"
+	codeHoldNotFound         = "HOLD_NOT_FOUND"
+	codeHoldNotActive        = "HOLD_NOT_ACTIVE"
+	codeHoldExpired          = "HOLD_EXPIRED"
+	codeCaptureExceedsHold   = "CAPTURE_EXCEEDS_HOLD"
+	codeAccountClosed        = "ACCOUNT_CLOSED"
+	codeInvalidMemo          = "INVALID_MEMO"
+	codeInvalidReference     = "INVALID_REFERENCE"
+	codeDuplicateReference   = "DUPLICATE_REFERENCE"
+	codeInvalidIdempotency   = "INVALID_IDEMPOTENCY_KEY"
+	codeIdempotencyReused    = "IDEMPOTENCY_KEY_REUSED"
+	codeSameAccount          = "SAME_ACCOUNT"
+	codeAlreadyCredited      = "ALREADY_CREDITED"
+	codeOpeningLimitExceeded = "OPENING_LIMIT_EXCEEDED"
+	codeDailyLimitExceeded   = "DAILY_LIMIT_EXCEEDED"
+	codeWelcomeDisabled      = "WELCOME_CREDIT_DISABLED"
+	codeInvalidReason        = "INVALID_REASON"
+	codeTopupLimitExceeded   = "TOPUP_LIMIT_EXCEEDED"
+	codeBankTopupExceeded    = "BANK_TOPUP_LIMIT_EXCEEDED"
+	codeInsufficientFunds    = "INSUFFICIENT_FUNDS"
+	codeBankAccount          = "BANK_ACCOUNT"
+	codeDeltaMode            = "DELTA_MODE"
+	codeSweepRequired        = "SWEEP_ACCOUNT_REQUIRED"
+	codeInvalidCurrency      = "INVALID_CURRENCY"
+	codeUnknownCurrency      = "UNKNOWN_CURRENCY"
+	codeInvalidDigits        = "INVALID_DIGITS"
+	codeDigitsFixed          = "DIGITS_FIXED"
+	codeUnknownTimeZone      = "UNKNOWN_TIME_ZONE"
+	codeInvalidCutOffHour    = "INVALID_CUTOFF_HOUR"
+	codeInvalidRetention     = "INVALID_RETENTION"
+	codeInvalidQuorum        = "INVALID_QUORUM"
+	codeInvalidExpiry        = "INVALID_EXPIRY"
+	codeInvalidLimitScope    = "INVALID_LIMIT_SCOPE"
+	codeInvalidLimitKind     = "INVALID_LIMIT_KIND"
+	codeTargetRequired       = "TARGET_REQUIRED"
+	codeUnknownRole          = "UNKNOWN_ROLE"
+	codeOwnAdminRole         = "OWN_ADMIN_ROLE"
+)
+
+// languages of the messages, the caller picks one with the "lang" transient field
+const (
+	langEnglish = "en"
+	langFrench  = "fr"
+)
+
+// messages holds the text of every code per language, {name} is replaced
+// by the field of the error with that name
+var messages = map[string]map[string]string{
+	langEnglish: {
+		codeInternal:             "Internal error: {detail}",
+		codeUnknownFunction:      "Invalid invoke function name {function}",
+		codeWrongArgumentCount:   "Incorrect number of arguments for {function}. Expecting {expected}",
+		codeInvalidArgument:      "Invalid argument {argument} for {function}, expecting a {type} value",
+		codeInvalidFormat:        "Invalid format {format}, expecting compact or verbose",
+		codeInvalidPageSize:      "Invalid page size {pagesize}, expecting a value between 1 and {max}",
+		codeInvalidOrder:         "Invalid order {order}, expecting asc or desc",
+		codeInvalidTime:          "Invalid time {time}, expecting an RFC 3339 timestamp such as 2017-06-26T09:00:00Z",
+		codeInvalidDay:           "Invalid day {day}, expecting a date such as 2017-06-26",
+		codeInvalidPeriod:        "Invalid period from {from} to {to}, the end comes before the start",
+		codeInvalidFilter:        "Invalid filter {filter}: {detail}",
+		codeInvalidSelector:      "Invalid selector {selector}: {detail}",
+		codeInvalidAmount:        "Invalid amount {amount}, expecting a decimal value such as 12.50",
+		codeTooManyDecimals:      "Invalid amount {amount}, expecting at most {digits} decimals",
+		codeAmountTooLarge:       "Invalid amount {amount}, value too large",
+		codeAmountOverflow:       "Amount overflow, operation cancelled",
+		codeEmptyReserve:         "Invalid amount {amount}, the bank reserve cannot be empty",
+		codeInvalidIdentity:      "Invalid caller identity: {detail}",
+		codeAccessDenied:         "{function} requires one of the roles {roles}",
+		codeNotOwner:             "Sorry but you are not the owner of account {account}. Transaction cancelled",
+		codeAccountNotFound:      "Account {account} not found",
+		codeNotAnAccount:         "{account} is not an account",
+		codeInvalidAccountName:   "Invalid account name {account}, expecting {min} to {max} capital letters, digits or _ starting with a letter",
+		codeReservedAccountName:  "Account name {account} is reserved for the bank",
+		codeAccountExists:        "Account {account} already exists",
+		codeInvalidOwner:         "Invalid owner {mspid}/{cn}, expecting an MSP ID without / and a common name",
+		codeUnknownProduct:       "Unknown product {product}, expecting one of {products}",
+		codeTransferNotFound:     "Transfer {txid} not found",
+		codeTransferNotPending:   "Transfer {txid} is {status}, not waiting for approval",
+		codeTransferExpired:      "Transfer {txid} expired on {expires}",
+		codeOwnTransfer:          "Transfer {txid} cannot be approved or rejected by its requester",
+		codeAlreadyApproved:      "Transfer {txid} was already approved by the caller",
+		codeFundsHeld:            "Account {account} has held funds",
+		codeHoldNotFound:         "Hold {hold} not found",
+		codeHoldNotActive:        "Hold {hold} is {status}, it can no longer be captured or released",
+		codeHoldExpired:          "Hold {hold} expired on {expires}",
+		codeCaptureExceedsHold:   "Amount {amount} exceeds the {held} {currency} of hold {hold}",
+		codeAccountClosed:        "Account {account} is closed",
+		codeInvalidMemo:          "Invalid memo, expecting at most {max} printable characters",
+		codeInvalidReference:     "Invalid reference {reference}, expecting at most {max} letters, digits, spaces or / - ? : ( ) . , ' +",
+		codeDuplicateReference:   "Reference {reference} was already used by {account} on {businessday} in transaction {txid}",
+		codeInvalidIdempotency:   "Invalid idempotency key {key}, expecting 1 to {max} letters, digits or . _ : -",
+		codeIdempotencyReused:    "Idempotency key {key} of {account} was used on {businessday} by transaction {txid} with other parameters",
+		codeSameAccount:          "The debited and credited accounts must be different",
+		codeAlreadyCredited:      "Account {account} has already been credited by the bank in {currency}",
+		codeOpeningLimitExceeded: "Requested amount is too large, the bank credits at most {limit} {currency} on opening",
+		codeDailyLimitExceeded:   "Total amount for fund transfer is superior to {limit} {currency}",
+		codeWelcomeDisabled:      "The bank does not credit new wallets, account {account} has to be topped up by a teller",
+		codeInvalidReason:        "Invalid reason {reason}, expecting one of {reasons}",
+		codeTopupLimitExceeded:   "Top-up of {account} is too large, tellers top it up by at most {limit} {currency} a day",
+		codeBankTopupExceeded:    "Top-up is too large, tellers top up accounts by at most {limit} {currency} a day in all",
+		codeInsufficientFunds:    "Insufficient funds in account {account}",
+		codeBankAccount:          "{function} is not available for the bank account",
+		codeDeltaMode:            "{function} is not available for account {account} in delta mode, its record does not hold its balance",
+		codeSweepRequired:        "Balance of account {account} is not zero, another account to sweep it to is required",
+		codeInvalidCurrency:      "Invalid currency code {currency}, expecting an ISO-4217 code",
+		codeUnknownCurrency:      "Unknown currency {currency}",
+		codeInvalidDigits:        "Invalid number of digits, expecting at most {max}",
+		codeDigitsFixed:          "The digits of {currency} cannot change once it is registered",
+		codeUnknownTimeZone:      "Unknown time zone {timezone}",
+		codeInvalidCutOffHour:    "Invalid cut-off hour, expecting a value between 0 and 23",
+		codeInvalidRetention:     "Invalid retention, expecting at least 1 business day",
+		codeInvalidQuorum:        "Invalid quorum {quorum}, expecting at least 1 approval",
+		codeInvalidExpiry:        "Invalid expiry {expiry}, expecting at least 1 business day",
+		codeInvalidLimitScope:    "Invalid limit scope {scope}, expecting default, tier, account or bank",
+		codeInvalidLimitKind:     "Invalid limit kind {kind} for the {scope} scope, expecting {kinds}",
+		codeTargetRequired:       "A target is required for the {scope} scope",
+		codeUnknownRole:          "Unknown role {role}",
+		codeOwnAdminRole:         "A bank admin cannot revoke its own bankadmin role",
+	},
+	langFrench: {
+		codeInternal:             "Erreur interne : {detail}",
+		codeUnknownFunction:      "Fonction {function} inconnue",
+		codeWrongArgumentCount:   "Nombre d'arguments incorrect pour {function}. Attendu : {expected}",
+		codeInvalidArgument:      "Argument {argument} invalide pour {function}, une valeur de type {type} est attendue",
+		codeInvalidFormat:        "Format {format} invalide, compact ou verbose est attendu",
+		codeInvalidPageSize:      "Taille de page {pagesize} invalide, une valeur entre 1 et {max} est attendue",
+		codeInvalidOrder:         "Ordre {order} invalide, asc ou desc est attendu",
+		codeInvalidTime:          "Date {time} invalide, un horodatage RFC 3339 tel que 2017-06-26T09:00:00Z est attendu",
+		codeInvalidDay:           "Jour {day} invalide, une date telle que 2017-06-26 est attendue",
+		codeInvalidPeriod:        "Période du {from} au {to} invalide, la fin précède le début",
+		codeInvalidFilter:        "Filtre {filter} invalide : {detail}",
+		codeInvalidSelector:      "Sélecteur {selector} invalide : {detail}",
+		codeInvalidAmount:        "Montant {amount} invalide, un nombre décimal tel que 12.50 est attendu",
+		codeTooManyDecimals:      "Montant {amount} invalide, au plus {digits} décimales sont acceptées",
+		codeAmountTooLarge:       "Montant {amount} invalide, valeur trop grande",
+		codeAmountOverflow:       "Dépassement de capacité, opération annulée",
+		codeEmptyReserve:         "Montant {amount} invalide, la réserve de la banque ne peut pas être vide",
+		codeInvalidIdentity:      "Identité de l'appelant invalide : {detail}",
+		codeAccessDenied:         "{function} nécessite l'un des rôles {roles}",
+		codeNotOwner:             "Désolé, vous n'êtes pas le titulaire du compte {account}. Transaction annulée",
+		codeAccountNotFound:      "Compte {account} introuvable",
+		codeNotAnAccount:         "{account} n'est pas un compte",
+		codeInvalidAccountName:   "Nom de compte {account} invalide, de {min} à {max} lettres majuscules, chiffres ou _ commençant par une lettre sont attendus",
+		codeReservedAccountName:  "Le nom de compte {account} est réservé à la banque",
+		codeAccountExists:        "Le compte {account} existe déjà",
+		codeInvalidOwner:         "Titulaire {mspid}/{cn} invalide, un MSP ID sans / et un nom commun sont attendus",
+		codeUnknownProduct:       "Produit {product} inconnu, l'un de {products} est attendu",
+		codeTransferNotFound:     "Virement {txid} introuvable",
+		codeTransferNotPending:   "Le virement {txid} est {status}, il n'attend pas d'approbation",
+		codeTransferExpired:      "Le virement {txid} a expiré le {expires}",
+		codeOwnTransfer:          "Le virement {txid} ne peut pas être approuvé ou rejeté par son demandeur",
+		codeAlreadyApproved:      "Le virement {txid} a déjà été approuvé par l'appelant",
+		codeFundsHeld:            "Le compte {account} a des fonds bloqués",
+		codeHoldNotFound:         "Autorisation {hold} introuvable",
+		codeHoldNotActive:        "L'autorisation {hold} est {status}, elle ne peut plus être débitée ou libérée",
+		codeHoldExpired:          "L'autorisation {hold} a expiré le {expires}",
+		codeCaptureExceedsHold:   "Le montant {amount} dépasse les {held} {currency} de l'autorisation {hold}",
+		codeAccountClosed:        "Le compte {account} est clôturé",
+		codeInvalidMemo:          "Libellé invalide, au plus {max} caractères imprimables sont acceptés",
+		codeInvalidReference:     "Référence {reference} invalide, au plus {max} lettres, chiffres, espaces ou / - ? : ( ) . , ' + sont acceptés",
+		codeDuplicateReference:   "La référence {reference} a déjà été utilisée par {account} le {businessday} dans la transaction {txid}",
+		codeInvalidIdempotency:   "Clé d'idempotence {key} invalide, de 1 à {max} lettres, chiffres ou . _ : - sont acceptés",
+		codeIdempotencyReused:    "La clé d'idempotence {key} de {account} a été utilisée le {businessday} par la transaction {txid} avec d'autres paramètres",
+		codeSameAccount:          "Les comptes débité et crédité doivent être différents",
+		codeAlreadyCredited:      "Le compte {account} a déjà été crédité par la banque en {currency}",
+		codeOpeningLimitExceeded: "Montant demandé trop important, la banque crédite au plus {limit} {currency} à l'ouverture",
+		codeDailyLimitExceeded:   "Le montant total des virements dépasse {limit} {currency}",
+		codeWelcomeDisabled:      "La banque ne crédite pas les nouveaux portefeuilles, le compte {account} doit être approvisionné par un guichetier",
+		codeInvalidReason:        "Motif {reason} invalide, l'un de {reasons} est attendu",
+		codeTopupLimitExceeded:   "Approvisionnement de {account} trop élevé, les guichetiers l'approvisionnent d'au plus {limit} {currency} par jour",
+		codeBankTopupExceeded:    "Approvisionnement trop élevé, les guichetiers approvisionnent les comptes d'au plus {limit} {currency} par jour au total",
+		codeInsufficientFunds:    "Provision insuffisante sur le compte {account}",
+		codeBankAccount:          "{function} n'est pas disponible pour le compte de la banque",
+		codeDeltaMode:            "{function} n'est pas disponible pour le compte {account} en mode delta, son enregistrement ne porte pas son solde",
+		codeSweepRequired:        "Le solde du compte {account} n'est pas nul, un autre compte vers lequel le virer est requis",
+		codeInvalidCurrency:      "Code devise {currency} invalide, un code ISO-4217 est attendu",
+		codeUnknownCurrency:      "Devise {currency} inconnue",
+		codeInvalidDigits:        "Nombre de décimales invalide, au plus {max} sont acceptées",
+		codeDigitsFixed:          "Le nombre de décimales de {currency} ne peut plus changer",
+		codeUnknownTimeZone:      "Fuseau horaire {timezone} inconnu",
+		codeInvalidCutOffHour:    "Heure de clôture invalide, une valeur entre 0 et 23 est attendue",
+		codeInvalidRetention:     "Durée de conservation invalide, au moins 1 jour ouvré est attendu",
+		codeInvalidQuorum:        "Quorum {quorum} invalide, au moins 1 approbation est attendue",
+		codeInvalidExpiry:        "Délai d'expiration {expiry} invalide, au moins 1 jour ouvré est attendu",
+		codeInvalidLimitScope:    "Portée de plafond {scope} invalide, default, tier, account ou bank est attendu",
+		codeInvalidLimitKind:     "Type de plafond {kind} invalide pour la portée {scope}, l'un de {kinds} est attendu",
+		codeTargetRequired:       "Une cible est requise pour la portée {scope}",
+		codeUnknownRole:          "Rôle {role} inconnu",
+		codeOwnAdminRole:         "Un administrateur ne peut pas révoquer son propre rôle bankadmin",
+	},
+}
+
+// details are the fields of an error, e.g. the account or the currency at fault
+type details map[string]string
+
+// chaincodeError is an error with a stable code, returned to the client as JSON
+type chaincodeError struct {
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+	Fields  details `json:"fields,omitempty"`
+}
+
+func newError(code string, fields details) *chaincodeError {
+	return &chaincodeError{Code: code, Fields: fields}
+}
+
+// internalError wraps an error that has no code of its own
+func internalError(err error) *chaincodeError {
+	if e, ok := err.(*chaincodeError); ok {
+		return e
+	}
+	return newError(codeInternal, details{"detail": err.Error()})
+}
+
+// Error returns the English message, used in logs
+func (e *chaincodeError) Error() string {
+	return e.message(langEnglish)
+}
+
+// message renders the text of the error in a language, English if it has no translation
+func (e *chaincodeError) message(lang string) string {
+	text, ok := messages[lang][e.Code]
+	if !ok {
+		text = messages[langEnglish][e.Code]
+	}
+	for name, value := range e.Fields {
+		text = strings.Replace(text, "{"+name+"}", value, -1)
+	}
+	return text
+}
+
+// isError tells whether err carries the code
+func isError(err error, code string) bool {
+	e, ok := err.(*chaincodeError)
+	return ok && e.Code == code
+}
+
+// language returns the language asked by the caller in the "lang" transient
+// field, e.g. fr or fr-FR, and English when it is missing or unknown
+func language(stub shim.ChaincodeStubInterface) string {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return langEnglish
+	}
+	lang := strings.ToLower(string(transient["lang"]))
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	if _, ok := messages[lang]; !ok {
+		return langEnglish
+	}
+	return lang
+}
+
+// errorResponse returns the error as a JSON object in the language of the
+// caller. The same JSON is used as message and payload of the response.
+func errorResponse(stub shim.ChaincodeStubInterface, err error) pb.Response {
+	e := *internalError(err)
+	e.Message = e.message(language(stub))
+	errbytes, merr := json.Marshal(e)
+	if merr != nil {
+		return shim.Error(e.Message)
+	}
+	return pb.Response{Status: shim.ERROR, Message: string(errbytes), Payload: errbytes}
+}
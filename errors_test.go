@@ -0,0 +1,157 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// transientStub returns a fixed transient map
+type transientStub struct {
+	*shim.MockStub
+	transient map[string][]byte
+}
+
+func (stub *transientStub) GetTransient() (map[string][]byte, error) {
+	return stub.transient, nil
+}
+
+// checkError runs an invocation expected to fail with the code and returns the error
+func checkError(t *testing.T, stub *shim.MockStub, code string, args [][]byte) *chaincodeError {
+	res := stub.MockInvoke("1", args)
+	if res.Status == shim.OK {
+		fmt.Println("Invoke", string(args[0]), "succeeded but was expected to fail with", code)
+		t.FailNow()
+	}
+	e := &chaincodeError{}
+	if err := json.Unmarshal(res.Payload, e); err != nil || res.Message != string(res.Payload) {
+		fmt.Println("Invoke", string(args[0]), "did not return a JSON error", res.Message)
+		t.FailNow()
+	}
+	if e.Code != code || e.Message == "" {
+		fmt.Println("Invoke", string(args[0]), "failed with", res.Message, "instead of", code)
+		t.FailNow()
+	}
+	return e
+}
+
+func TestErrors_Codes(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE", "COMPTE_FABIEN")
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte("jyg"), []byte("customer")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("100")})
+
+	checkError(t, stub, codeUnknownFunction, [][]byte{[]byte("invoke")})
+	checkError(t, stub, codeWrongArgumentCount, [][]byte{[]byte("query")})
+	checkError(t, stub, codeInvalidArgument, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("abc")})
+	checkError(t, stub, codeTooManyDecimals, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1.234")})
+	checkError(t, stub, codeOpeningLimitExceeded, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_FABIEN"), []byte("100000")})
+	checkError(t, stub, codeAlreadyCredited, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("10")})
+	checkError(t, stub, codeUnknownCurrency, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10"), []byte("GBP")})
+	checkError(t, stub, codeSameAccount, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_JYG"), []byte("10")})
+	checkError(t, stub, codeAccountNotFound, [][]byte{[]byte("query"), []byte("COMPTE_NOBODY")})
+	checkError(t, stub, codeNotAnAccount, [][]byte{[]byte("query"), []byte("MPLBANK_LIMITS")})
+
+	e := checkError(t, stub, codeDailyLimitExceeded, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1500")})
+	if e.Fields["account"] != "COMPTE_JYG" || e.Fields["limit"] != "1000.00" || e.Fields["currency"] != "EUR" {
+		fmt.Println("unexpected fields", e.Fields)
+		t.FailNow()
+	}
+	if e.Message != "Total amount for fund transfer is superior to 1000.00 EUR" {
+		fmt.Println("unexpected message", e.Message)
+		t.FailNow()
+	}
+
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkError(t, stub, codeAccessDenied, [][]byte{[]byte("query"), []byte("COMPTE_JYG")})
+	checkInvoke(t, stub, [][]byte{[]byte("whoami")})
+
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte("karine"), []byte("customer")})
+	setCreator(t, stub, "Org1MSP", "karine")
+	e = checkError(t, stub, codeNotOwner, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10")})
+	if e.Fields["account"] != "COMPTE_JYG" {
+		fmt.Println("unexpected fields", e.Fields)
+		t.FailNow()
+	}
+
+	// COMPTE_KARINE was opened by jyg
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkError(t, stub, codeInsufficientFunds, [][]byte{[]byte("move"), []byte("COMPTE_KARINE"), []byte("COMPTE_JYG"), []byte("100.01")})
+}
+
+func TestErrors_Messages(t *testing.T) {
+	// every code has a message in every language
+	for lang, catalog := range messages {
+		if len(catalog) != len(messages[langEnglish]) {
+			fmt.Println("messages in", lang, "do not cover every code")
+			t.FailNow()
+		}
+		for code, text := range catalog {
+			if _, ok := messages[langEnglish][code]; !ok || text == "" {
+				fmt.Println("unexpected message", code, "in", lang)
+				t.FailNow()
+			}
+		}
+	}
+
+	e := newError(codeInsufficientFunds, details{"account": "COMPTE_JYG"})
+	if e.message(langFrench) != "Provision insuffisante sur le compte COMPTE_JYG" || e.Error() != "Insufficient funds in account COMPTE_JYG" {
+		fmt.Println("unexpected messages", e.message(langFrench), e.Error())
+		t.FailNow()
+	}
+	if internalError(e) != e || !strings.HasPrefix(internalError(fmt.Errorf("boom")).Error(), "Internal error: boom") {
+		fmt.Println("unexpected internal error")
+		t.FailNow()
+	}
+
+	stub := &transientStub{MockStub: shim.NewMockStub("ex02", new(SimpleChaincode))}
+	tests := []struct {
+		lang     string
+		expected string
+	}{
+		{"fr", langFrench},
+		{"fr-FR", langFrench},
+		{"FR", langFrench},
+		{"en_GB", langEnglish},
+		{"de", langEnglish},
+		{"", langEnglish},
+	}
+	for _, test := range tests {
+		stub.transient = map[string][]byte{"lang": []byte(test.lang)}
+		if lang := language(stub); lang != test.expected {
+			fmt.Println("language of", test.lang, "was", lang, "instead of", test.expected)
+			t.FailNow()
+		}
+	}
+
+	stub.transient = map[string][]byte{"lang": []byte("fr")}
+	res := errorResponse(stub, e)
+	if res.Message != `{"code":"INSUFFICIENT_FUNDS","message":"Provision insuffisante sur le compte COMPTE_JYG","fields":{"account":"COMPTE_JYG"}}` {
+		fmt.Println("unexpected response", res.Message)
+		t.FailNow()
+	}
+}
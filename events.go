@@ -0,0 +1,190 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Chaincode events let off-chain systems follow balance changes without
+// polling. Fabric keeps a single event per transaction, it is set right
+// before the handler returns successfully so a failed transaction emits
+// nothing. The schema of every event is documented in EVENTS.md, keep it in
+// sync with the payload types below.
+const (
+	eventTransfer      = "Transfer"
+	eventAccountOpened = "AccountOpened"
+	eventAccountClosed = "AccountClosed"
+	eventDayChanged    = "DayChanged"
+
+	eventTransferPending  = "TransferPending"
+	eventTransferReleased = "TransferReleased"
+	eventTransfersExpired = "TransfersExpired"
+	eventHoldPlaced       = "HoldPlaced"
+	eventHoldReleased     = "HoldReleased"
+	eventHoldsExpired     = "HoldsExpired"
+	eventIssued           = "Issued"
+	eventConsolidated     = "Consolidated"
+)
+
+// version of the payloads described below
+const eventVersion = 1
+
+// eventHeader is common to every event
+type eventHeader struct {
+	Event        string `json:"event"`          // name of the event, repeated for consumers storing payloads
+	Version      int    `json:"version"`        // eventVersion
+	TxID         string `json:"txid"`           // transaction that emitted the event
+	BusinessDay  string `json:"businessday"`    // business day of the transaction, e.g. 2017-06-26
+	Requester    string `json:"requester"`      // common name of the caller
+	RequesterMSP string `json:"requestermspid"` // MSP ID of the caller
+}
+
+func newEventHeader(stub shim.ChaincodeStubInterface, event string, day string, caller *identity) eventHeader {
+	return eventHeader{Event: event, Version: eventVersion, TxID: stub.GetTxID(), BusinessDay: day, Requester: caller.CN, RequesterMSP: caller.MSPID}
+}
+
+// transferEvent is the payload of Transfer, balances are the ones after the move
+type transferEvent struct {
+	eventHeader
+	Debit         string      `json:"debit"`
+	Credit        string      `json:"credit"`
+	Currency      string      `json:"currency"`
+	Amount        amountValue `json:"amount"`
+	DebitBalance  amountValue `json:"debitbalance"`
+	CreditBalance amountValue `json:"creditbalance"`
+	Memo          string      `json:"memo,omitempty"`
+	Reference     string      `json:"reference,omitempty"` // end-to-end reference of the payer
+	Approved      string      `json:"approved,omitempty"`  // TxID of the pending move executed by approvetransfer
+	Hold          string      `json:"hold,omitempty"`      // ID of the hold settled by capturehold
+}
+
+// transferPendingEvent is the payload of TransferPending, the TxID of the header identifies the pending transfer
+type transferPendingEvent struct {
+	eventHeader
+	Debit        string      `json:"debit"`
+	Credit       string      `json:"credit"`
+	Currency     string      `json:"currency"`
+	Amount       amountValue `json:"amount"`
+	DebitBalance amountValue `json:"debitbalance"` // balance of the debit account, the amount no longer in it
+	Held         amountValue `json:"held"`         // held balance of the debit account, the amount included
+	Memo         string      `json:"memo,omitempty"`
+	Reference    string      `json:"reference,omitempty"`
+	Expires      string      `json:"expires"` // first business day it can no longer be approved
+	Quorum       int         `json:"quorum"`  // approvals executing it
+}
+
+// transferRelease describes a pending transfer released, the amount is back in the debit balance
+type transferRelease struct {
+	Transfer     string      `json:"transfer"` // TxID of the pending move
+	Debit        string      `json:"debit"`
+	Credit       string      `json:"credit"`
+	Currency     string      `json:"currency"`
+	Amount       amountValue `json:"amount"`
+	DebitBalance amountValue `json:"debitbalance"`
+	Status       string      `json:"status"` // REJECTED, EXPIRED
+}
+
+// transferReleasedEvent is the payload of TransferReleased
+type transferReleasedEvent struct {
+	eventHeader
+	transferRelease
+}
+
+// transfersExpiredEvent is the payload of TransfersExpired, Fabric keeps one event per transaction
+type transfersExpiredEvent struct {
+	eventHeader
+	Transfers []transferRelease `json:"transfers"`
+}
+
+// accountOpenedEvent is the payload of AccountOpened, the credit is the new account
+type accountOpenedEvent struct {
+	transferEvent
+	Owner   string `json:"owner"`   // MSP ID and common name of the owner
+	Product string `json:"product"` // product type of the account
+}
+
+// accountClosedEvent is the payload of AccountClosed
+type accountClosedEvent struct {
+	eventHeader
+	Account      string                 `json:"account"`
+	Owner        string                 `json:"owner"`
+	SweepTo      string                 `json:"sweepto,omitempty"`      // empty when the account held no money
+	Swept        map[string]amountValue `json:"swept"`                  // amount moved to SweepTo per currency
+	SweepBalance map[string]amountValue `json:"sweepbalance,omitempty"` // balances of SweepTo after the sweep
+}
+
+// dayChangedEvent is the payload of DayChanged, BusinessDay in the header is the new day
+type dayChangedEvent struct {
+	eventHeader
+	PreviousDay string `json:"previousday"`
+	Offset      int    `json:"offset"` // days added to the calendar so far
+}
+
+// holdChange describes a hold placed or released, balances are the ones of the account after the change
+type holdChange struct {
+	Hold     string      `json:"hold"` // ID of the hold, the TxID of placehold
+	Account  string      `json:"account"`
+	Merchant string      `json:"merchant"`
+	Currency string      `json:"currency"`
+	Amount   amountValue `json:"amount"`
+	Balance  amountValue `json:"balance"` // available balance of the account
+	Held     amountValue `json:"held"`    // held balance of the account
+	Expires  string      `json:"expires"` // first business day the hold can no longer be captured
+	Status   string      `json:"status"`  // ACTIVE, RELEASED, EXPIRED
+}
+
+// holdEvent is the payload of HoldPlaced and HoldReleased
+type holdEvent struct {
+	eventHeader
+	holdChange
+}
+
+// holdsExpiredEvent is the payload of HoldsExpired, Fabric keeps one event per transaction
+type holdsExpiredEvent struct {
+	eventHeader
+	Holds []holdChange `json:"holds"`
+}
+
+// issuedEvent is the payload of Issued, the new money is in the reserve
+type issuedEvent struct {
+	eventHeader
+	Currency string      `json:"currency"`
+	Amount   amountValue `json:"amount"`
+	Reserve  amountValue `json:"reserve"` // balance of MPLBANK in the currency, deltas included
+	Supply   amountValue `json:"supply"`  // money issued in the currency so far
+}
+
+// consolidatedEvent is the payload of Consolidated, the balances of the
+// record of an account in delta mode now include its deltas
+type consolidatedEvent struct {
+	eventHeader
+	Account  string                 `json:"account"`
+	Deltas   int                    `json:"deltas"` // deltas folded in
+	Balances map[string]amountValue `json:"balances"`
+}
+
+// setEvent marshals the payload and sets it as the event of the transaction
+func setEvent(stub shim.ChaincodeStubInterface, name string, payload interface{}) error {
+	payloadbytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(name, payloadbytes)
+}
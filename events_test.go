@@ -0,0 +1,129 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// lastEvent drains the events emitted so far and returns the last one, nil if there was none
+func lastEvent(stub *shim.MockStub) *pb.ChaincodeEvent {
+	var last *pb.ChaincodeEvent
+	for {
+		select {
+		case e := <-stub.ChaincodeEventsChannel:
+			last = e
+		default:
+			return last
+		}
+	}
+}
+
+func checkEvent(t *testing.T, stub *shim.MockStub, name string, payload interface{}) {
+	e := lastEvent(stub)
+	if e == nil || e.EventName != name {
+		fmt.Println("expected event", name, "got", e)
+		t.FailNow()
+	}
+	err := json.Unmarshal(e.Payload, payload)
+	if err != nil {
+		fmt.Println("invalid payload for", name, string(e.Payload))
+		t.FailNow()
+	}
+}
+
+func TestEvents_Move(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_KARINE")
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte("jyg"), []byte("customer")})
+	lastEvent(stub)
+
+	checkInvoke(t, stub, [][]byte{[]byte("openaccount"), []byte("COMPTE_JYG"), []byte("Org1MSP"), []byte("jyg"), []byte(productCurrent), []byte("2000")})
+	var opened accountOpenedEvent
+	checkEvent(t, stub, eventAccountOpened, &opened)
+	if opened.Event != eventAccountOpened || opened.Version != eventVersion || opened.TxID != "1" || opened.Requester != "jyg" ||
+		opened.RequesterMSP != "Org1MSP" || opened.BusinessDay == "" || opened.Owner != "Org1MSP/jyg" || opened.Product != productCurrent ||
+		opened.Debit != "MPLBANK" || opened.Credit != "COMPTE_JYG" || opened.Currency != "EUR" ||
+		opened.Amount != (amountValue{"2000.00", "200000"}) || opened.CreditBalance != (amountValue{"2000.00", "200000"}) ||
+		opened.DebitBalance != (amountValue{"898000.00", "89800000"}) {
+		fmt.Println("unexpected AccountOpened", opened)
+		t.FailNow()
+	}
+
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("100")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10.50")})
+	var transfer transferEvent
+	checkEvent(t, stub, eventTransfer, &transfer)
+	if transfer.Event != eventTransfer || transfer.Debit != "COMPTE_JYG" || transfer.Credit != "COMPTE_KARINE" ||
+		transfer.Amount != (amountValue{"10.50", "1050"}) || transfer.DebitBalance != (amountValue{"1989.50", "198950"}) ||
+		transfer.CreditBalance != (amountValue{"110.50", "11050"}) {
+		fmt.Println("unexpected Transfer", transfer)
+		t.FailNow()
+	}
+
+	// nothing is emitted when the transaction fails
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("5000")})
+	if e := lastEvent(stub); e != nil {
+		fmt.Println("failed move emitted", e.EventName)
+		t.FailNow()
+	}
+}
+
+func TestEvents_CloseAndChangeDay(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("5000"), []byte("USD")})
+	var issued issuedEvent
+	checkEvent(t, stub, eventIssued, &issued)
+	if issued.Currency != "USD" || issued.Amount != (amountValue{"5000.00", "500000"}) || issued.Reserve.Amount != "5000.00" || issued.Supply.Amount != "5000.00" {
+		fmt.Println("unexpected Issued", issued)
+		t.FailNow()
+	}
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("30"), []byte("USD")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("100")})
+
+	checkInvoke(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE")})
+	var closed accountClosedEvent
+	checkEvent(t, stub, eventAccountClosed, &closed)
+	if closed.Account != "COMPTE_JYG" || closed.Owner != "Org1MSP/jyg" || closed.SweepTo != "COMPTE_KARINE" ||
+		len(closed.Swept) != 2 || closed.Swept["USD"] != (amountValue{"30.00", "3000"}) ||
+		closed.SweepBalance["EUR"] != (amountValue{"2100.00", "210000"}) {
+		fmt.Println("unexpected AccountClosed", closed)
+		t.FailNow()
+	}
+
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	var changed dayChangedEvent
+	checkEvent(t, stub, eventDayChanged, &changed)
+	if changed.Offset != 1 || changed.PreviousDay == "" || changed.PreviousDay >= changed.BusinessDay {
+		fmt.Println("unexpected DayChanged", changed)
+		t.FailNow()
+	}
+}
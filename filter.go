@@ -0,0 +1,94 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// accountFilter selects accounts in getaccounts. It is given as a JSON
+// object, e.g. {"owner":"Org1MSP/jyg","currency":"USD","minbalance":"10.00"},
+// every field is optional and all the given ones must match.
+type accountFilter struct {
+	Owner      string `json:"owner"`      // MSP ID and common name of the owner
+	Status     string `json:"status"`     // OPEN or CLOSED
+	Currency   string `json:"currency"`   // accounts holding the currency, also the currency of the balance bounds
+	MinBalance string `json:"minbalance"` // inclusive, decimal in major units
+	MaxBalance string `json:"maxbalance"` // inclusive, decimal in major units
+
+	currency string // resolved currency of the balance bounds
+	min, max uint64 // bounds in minor units
+	hasMax   bool
+}
+
+// parseAccountFilter decodes and validates a filter, an empty one matches every account
+func parseAccountFilter(s string, c *currencies) (*accountFilter, error) {
+	f := &accountFilter{}
+	if s != "" {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(s)))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(f)
+		if err != nil {
+			return nil, newError(codeInvalidFilter, details{"filter": s, "detail": err.Error()})
+		}
+	}
+
+	switch f.Status {
+	case "", statusOpen, statusClosed:
+	default:
+		return nil, newError(codeInvalidFilter, details{"filter": s, "detail": "status must be " + statusOpen + " or " + statusClosed})
+	}
+
+	var err error
+	f.currency, err = c.resolve(f.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if f.MinBalance != "" {
+		f.min, err = c.parse(f.MinBalance, f.currency)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if f.MaxBalance != "" {
+		f.max, err = c.parse(f.MaxBalance, f.currency)
+		if err != nil {
+			return nil, err
+		}
+		f.hasMax = true
+	}
+	return f, nil
+}
+
+// match tells whether the account passes the filter
+func (f *accountFilter) match(acc *account) bool {
+	if f.Owner != "" && acc.Owner != f.Owner {
+		return false
+	}
+	if f.Status == statusClosed && !acc.isClosed() || f.Status == statusOpen && acc.isClosed() {
+		return false
+	}
+	balance, held := acc.Balances[f.currency]
+	if f.Currency != "" && !held {
+		return false
+	}
+	if balance < f.min || f.hasMax && balance > f.max {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkFilter(t *testing.T, stub *shim.MockStub, filter string, expected string) {
+	res := stub.MockInvoke("1", [][]byte{[]byte("getaccounts"), []byte(""), []byte(""), []byte(""), []byte(filter)})
+	if res.Status != shim.OK {
+		fmt.Println("getaccounts", filter, "failed", res.Message)
+		t.FailNow()
+	}
+	var resp accountListResponse
+	if err := json.Unmarshal(res.Payload, &resp); err != nil {
+		fmt.Println("getaccounts returned invalid JSON", string(res.Payload))
+		t.FailNow()
+	}
+	names := []string{}
+	for _, acc := range resp.Accounts {
+		names = append(names, acc.Name)
+	}
+	if fmt.Sprint(names) != expected {
+		fmt.Println("getaccounts", filter, "returned", names, "instead of", expected)
+		t.FailNow()
+	}
+}
+
+func TestFilter_Accounts(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("5000"), []byte("USD")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("30"), []byte("USD")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("100")})
+	checkInvoke(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_KARINE"), []byte("COMPTE_JYG")})
+	stub.MockTransactionStart("legacy")
+	// names are not what tells accounts from the system records, move used to open any name
+	stub.PutState("MPLBANK_CLIENT", []byte(`{"docType":"ACCOUNT","name":"MPLBANK_CLIENT","balances":{"EUR":6000},"totalsforday":{},"owner":"Org1MSP/jyg","status":"OPEN"}`))
+	stub.PutState("LEGACY", []byte(`{"docType":"ACCOUNT","name":"LEGACY","currentbalance":50,"owner":"jyg"}`))
+	stub.MockTransactionEnd("legacy")
+
+	checkFilter(t, stub, "", "[COMPTE_JYG COMPTE_KARINE LEGACY MPLBANK MPLBANK_CLIENT]")
+	checkFilter(t, stub, "{}", "[COMPTE_JYG COMPTE_KARINE LEGACY MPLBANK MPLBANK_CLIENT]")
+	checkFilter(t, stub, `{"owner":"jyg"}`, "[LEGACY]")
+	checkFilter(t, stub, `{"status":"CLOSED"}`, "[COMPTE_KARINE]")
+	checkFilter(t, stub, `{"status":"OPEN","owner":"Org1MSP/jyg"}`, "[COMPTE_JYG MPLBANK MPLBANK_CLIENT]")
+	checkFilter(t, stub, `{"currency":"USD"}`, "[COMPTE_JYG MPLBANK]")
+	checkFilter(t, stub, `{"currency":"USD","minbalance":"100"}`, "[MPLBANK]")
+	checkFilter(t, stub, `{"minbalance":"1000","maxbalance":"5000"}`, "[COMPTE_JYG]")
+	checkFilter(t, stub, `{"maxbalance":"60"}`, "[COMPTE_KARINE LEGACY MPLBANK_CLIENT]")
+
+	checkError(t, stub, codeInvalidFilter, [][]byte{[]byte("getaccounts"), []byte(""), []byte(""), []byte(""), []byte("owner=jyg")})
+	checkError(t, stub, codeInvalidFilter, [][]byte{[]byte("getaccounts"), []byte(""), []byte(""), []byte(""), []byte(`{"name":"COMPTE_JYG"}`)})
+	checkError(t, stub, codeInvalidFilter, [][]byte{[]byte("getaccounts"), []byte(""), []byte(""), []byte(""), []byte(`{"status":"FROZEN"}`)})
+	checkError(t, stub, codeTooManyDecimals, [][]byte{[]byte("getaccounts"), []byte(""), []byte(""), []byte(""), []byte(`{"minbalance":"1.001"}`)})
+	checkError(t, stub, codeUnknownCurrency, [][]byte{[]byte("getaccounts"), []byte(""), []byte(""), []byte(""), []byte(`{"currency":"JPY"}`)})
+}
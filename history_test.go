@@ -0,0 +1,229 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// historyStub keeps the versions of every key, the mock stub has no history.
+// Its transactions run at a given time.
+type historyStub struct {
+	*shim.MockStub
+	cc       shim.Chaincode
+	args     []string
+	versions map[string][]*queryresult.KeyModification
+}
+
+func newHistoryStub(t *testing.T) *historyStub {
+	scc := new(SimpleChaincode)
+	stub := &historyStub{MockStub: shim.NewMockStub("ex02", scc), cc: scc, versions: map[string][]*queryresult.KeyModification{}}
+	setCreator(t, stub.MockStub, "Org1MSP", "jyg")
+	return stub
+}
+
+func (s *historyStub) GetFunctionAndParameters() (string, []string) {
+	if len(s.args) == 0 {
+		return "", []string{}
+	}
+	return s.args[0], s.args[1:]
+}
+
+func (s *historyStub) record(key string, value []byte, deleted bool) {
+	s.versions[key] = append(s.versions[key], &queryresult.KeyModification{TxId: s.TxID, Value: value, Timestamp: s.TxTimestamp, IsDelete: deleted})
+}
+
+func (s *historyStub) PutState(key string, value []byte) error {
+	err := s.MockStub.PutState(key, value)
+	if err == nil {
+		s.record(key, value, false)
+	}
+	return err
+}
+
+func (s *historyStub) DelState(key string) error {
+	err := s.MockStub.DelState(key)
+	if err == nil {
+		s.record(key, nil, true)
+	}
+	return err
+}
+
+func (s *historyStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{versions: s.versions[key]}, nil
+}
+
+// run executes Init or Invoke as the transaction txid at the time at
+func (s *historyStub) run(txid string, at time.Time, init bool, args ...string) pb.Response {
+	s.args = args
+	s.MockTransactionStart(txid)
+	s.TxTimestamp = &timestamp.Timestamp{Seconds: at.Unix(), Nanos: int32(at.Nanosecond())}
+	defer s.MockTransactionEnd(txid)
+	if init {
+		return s.cc.Init(s)
+	}
+	return s.cc.Invoke(s)
+}
+
+func (s *historyStub) checkRun(t *testing.T, txid string, at time.Time, args ...string) {
+	res := s.run(txid, at, false, args...)
+	if res.Status != shim.OK {
+		fmt.Println(args[0], "failed", res.Message)
+		t.FailNow()
+	}
+}
+
+type historyIterator struct {
+	versions []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool {
+	return len(it.versions) > 0
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	next := it.versions[0]
+	it.versions = it.versions[1:]
+	return next, nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
+
+// checkHistory calls gethistory and returns the TxIDs of the entries
+func checkHistory(t *testing.T, stub *historyStub, args ...string) (*historyResponse, string) {
+	res := stub.run("q", time.Now(), false, append([]string{"gethistory"}, args...)...)
+	if res.Status != shim.OK {
+		fmt.Println("gethistory", args, "failed", res.Message)
+		t.FailNow()
+	}
+	var resp historyResponse
+	if err := json.Unmarshal(res.Payload, &resp); err != nil {
+		fmt.Println("gethistory returned invalid JSON", string(res.Payload))
+		t.FailNow()
+	}
+	txids := []string{}
+	for _, entry := range resp.History {
+		txids = append(txids, entry.TxID)
+	}
+	return &resp, fmt.Sprint(txids)
+}
+
+func TestHistory_Entries(t *testing.T) {
+	stub := newHistoryStub(t)
+	day := time.Date(2017, 6, 26, 9, 0, 0, 0, time.UTC)
+
+	if res := stub.run("init", day, true, "init", "900000"); res.Status != shim.OK {
+		fmt.Println("Init failed", res.Message)
+		t.FailNow()
+	}
+	stub.checkRun(t, "t1", day.Add(time.Hour), "openaccount", "COMPTE_JYG", "Org1MSP", "jyg", productCurrent, "2000")
+	stub.checkRun(t, "t2", day.Add(2*time.Hour), "openaccount", "COMPTE_KARINE", "Org1MSP", "jyg", productCurrent, "100")
+	stub.checkRun(t, "t3", day.Add(3*time.Hour), "move", "COMPTE_JYG", "COMPTE_KARINE", "10.50", "", "", "LOYER-2017-06")
+
+	// an account removed by hand, its last version has no value
+	stub.MockTransactionStart("t4")
+	stub.TxTimestamp = &timestamp.Timestamp{Seconds: day.Add(4 * time.Hour).Unix()}
+	stub.DelState("COMPTE_KARINE")
+	stub.MockTransactionEnd("t4")
+
+	resp, txids := checkHistory(t, stub, "COMPTE_KARINE")
+	if txids != "[t2 t3 t4]" {
+		fmt.Println("unexpected history", txids)
+		t.FailNow()
+	}
+	last, credited := resp.History[2], resp.History[1]
+	if !last.Deleted || last.Timestamp != "2017-06-26T13:00:00Z" || last.Account != nil || last.Balance != "" {
+		fmt.Println("unexpected deleted entry", last)
+		t.FailNow()
+	}
+	if credited.Deleted || credited.Timestamp != "2017-06-26T12:00:00Z" || credited.Balance != "110.50" ||
+		credited.Account == nil || credited.Account.Owner != "Org1MSP/jyg" || credited.Account.Status != statusOpen ||
+		credited.Reference != "LOYER-2017-06" || credited.Memo != "" {
+		fmt.Println("unexpected entry", credited)
+		t.FailNow()
+	}
+
+	if _, txids = checkHistory(t, stub, "COMPTE_KARINE", "", "", "", "", "", "desc"); txids != "[t4 t3 t2]" {
+		fmt.Println("unexpected history in reverse order", txids)
+		t.FailNow()
+	}
+	if _, txids = checkHistory(t, stub, "COMPTE_JYG", "", "", "", "2017-06-26T10:00:00Z", "2017-06-26T11:59:59Z"); txids != "[t1]" {
+		fmt.Println("unexpected history between two times", txids)
+		t.FailNow()
+	}
+	if _, txids = checkHistory(t, stub, "MPLBANK", "", "", "", "2017-06-26T12:00:00+02:00"); txids != "[t1 t2]" {
+		fmt.Println("unexpected history from a time", txids)
+		t.FailNow()
+	}
+
+	// pages follow the order
+	resp, txids = checkHistory(t, stub, "MPLBANK", "", "2", "", "", "", "desc")
+	if txids != "[t2 t1]" || resp.Bookmark != "init" {
+		fmt.Println("unexpected first page", txids, resp.Bookmark)
+		t.FailNow()
+	}
+	if _, txids = checkHistory(t, stub, "MPLBANK", "", "2", resp.Bookmark, "", "", "desc"); txids != "[init]" {
+		fmt.Println("unexpected second page", txids)
+		t.FailNow()
+	}
+
+	// the record of an account in delta mode does not hold its balance
+	stub.checkRun(t, "d1", day.Add(5*time.Hour), "setdeltas", "COMPTE_JYG", "true")
+
+	for code, args := range map[string][]string{
+		codeInvalidArgument: {"gethistory", "MPLBANK", "", "", "", "yesterday"},
+		codeInvalidOrder:    {"gethistory", "MPLBANK", "", "", "", "", "", "newest"},
+		codeDeltaMode:       {"gethistory", "COMPTE_JYG"},
+	} {
+		res := stub.run("q", time.Now(), false, args...)
+		var e chaincodeError
+		if json.Unmarshal(res.Payload, &e) != nil || e.Code != code {
+			fmt.Println("gethistory", args, "returned", res.Message, "instead of", code)
+			t.FailNow()
+		}
+	}
+
+	// only the owner, the auditors and the bank admins read the history, of a deleted account only the last two
+	stub.checkRun(t, "g1", day.Add(6*time.Hour), "grantrole", "Org1MSP", "karine", "customer")
+	stub.checkRun(t, "g2", day.Add(6*time.Hour), "grantrole", "Org1MSP", "estelle", "auditor")
+	stub.checkRun(t, "g3", day.Add(6*time.Hour), "grantrole", "Org1MSP", "fabien", "teller")
+	for cn, code := range map[string]string{"karine": codeNotOwner, "fabien": codeAccessDenied} {
+		setCreator(t, stub.MockStub, "Org1MSP", cn)
+		for _, name := range []string{"COMPTE_JYG", "COMPTE_KARINE"} {
+			res := stub.run("q", time.Now(), false, "gethistory", name)
+			var e chaincodeError
+			if json.Unmarshal(res.Payload, &e) != nil || e.Code != code {
+				fmt.Println("gethistory of", name, "by", cn, "returned", res.Message)
+				t.FailNow()
+			}
+		}
+	}
+	setCreator(t, stub.MockStub, "Org1MSP", "estelle")
+	if _, txids = checkHistory(t, stub, "COMPTE_KARINE"); txids != "[t2 t3 t4]" {
+		fmt.Println("unexpected history for an auditor", txids)
+		t.FailNow()
+	}
+}
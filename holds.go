@@ -0,0 +1,669 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// A card payment is authorized before it is settled. placehold moves the
+// authorized amount from the balance of the account, its available balance,
+// to its held balance, which it shares with the pending transfers of
+// approvals.go, and counts it in its daily total like a move. capturehold
+// settles the hold for its amount or less, crediting the merchant account,
+// releasehold gives the amount back. The ID of a hold is the TxID
+// of placehold.
+//
+// A hold can no longer be captured from its Expires day on. Expired holds
+// are released when their account is next debited or closed, or by
+// expireholds. The account~hold index lists the active holds of an account.
+const (
+	holdIndex        = "hold"         // hold ID
+	accountHoldIndex = "account~hold" // account, hold ID
+)
+
+// the hold policy is kept in world state so it can change without a redeploy
+const holdsKey = "MPLBANK_HOLDS"
+
+// business days a hold is kept when the ledger holds no hold policy yet
+const defaultHoldExpiry = 7
+
+// status of a hold
+const (
+	activeHold   = "ACTIVE"
+	capturedHold = "CAPTURED"
+	releasedHold = "RELEASED"
+	expiredHold  = "EXPIRED"
+)
+
+type holdPolicy struct {
+	ObjectType string `json:"docType"`
+	Expiry     int    `json:"expiry"` // business days a hold can be captured
+}
+
+type hold struct {
+	ObjectType  string `json:"docType"`
+	ID          string `json:"id"`       // TxID of placehold
+	Account     string `json:"account"`  // account the amount is held on
+	Merchant    string `json:"merchant"` // account credited by the capture
+	Currency    string `json:"currency"`
+	Amount      uint64 `json:"amount"`             // minor units held
+	Captured    uint64 `json:"captured,omitempty"` // minor units settled by capturehold
+	Memo        string `json:"memo,omitempty"`
+	Requester   string `json:"requester"`   // MSP ID and common name of the caller of placehold
+	BusinessDay string `json:"businessday"` // business day of placehold
+	Expires     string `json:"expires"`     // first business day it can no longer be captured
+	Status      string `json:"status"`
+	DecidedBy   string `json:"decidedby,omitempty"` // TxID of the transaction that captured or released it
+}
+
+// holdResponse is the result of placehold, capturehold and releasehold
+type holdResponse struct {
+	Version     int    `json:"version"`
+	ID          string `json:"id"`
+	Account     string `json:"account"`
+	Merchant    string `json:"merchant"`
+	Currency    string `json:"currency"`
+	Amount      string `json:"amount"`
+	Captured    string `json:"captured,omitempty"`
+	BusinessDay string `json:"businessday"`
+	Expires     string `json:"expires"`
+	Status      string `json:"status"`
+}
+
+func newHoldResponse(h *hold, c *currencies) *holdResponse {
+	resp := &holdResponse{
+		Version:     responseVersion,
+		ID:          h.ID,
+		Account:     h.Account,
+		Merchant:    h.Merchant,
+		Currency:    h.Currency,
+		Amount:      c.format(h.Amount, h.Currency),
+		BusinessDay: h.BusinessDay,
+		Expires:     h.Expires,
+		Status:      h.Status,
+	}
+	if h.Status == capturedHold {
+		resp.Captured = c.format(h.Captured, h.Currency)
+	}
+	return resp
+}
+
+func getHoldPolicy(stub shim.ChaincodeStubInterface) (*holdPolicy, error) {
+	policybytes, err := stub.GetState(holdsKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", holdsKey)
+	}
+	policy := &holdPolicy{ObjectType: "HOLDS", Expiry: defaultHoldExpiry}
+	if policybytes == nil {
+		return policy, nil
+	}
+	err = json.Unmarshal(policybytes, policy)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", holdsKey)
+	}
+	return policy, nil
+}
+
+// isExpired tells whether the hold can no longer be captured on today, days sort as strings
+func (h *hold) isExpired(today string) bool {
+	return today >= h.Expires
+}
+
+func getHold(stub shim.ChaincodeStubInterface, id string) (*hold, error) {
+	key, err := stub.CreateCompositeKey(holdIndex, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	holdbytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for hold %s", id)
+	}
+	if holdbytes == nil {
+		return nil, newError(codeHoldNotFound, details{"hold": id})
+	}
+	h := &hold{}
+	err = json.Unmarshal(holdbytes, h)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of hold %s", id)
+	}
+	return h, nil
+}
+
+// putHold writes the hold, and its entry in the account~hold index while it is active
+func putHold(stub shim.ChaincodeStubInterface, h *hold) error {
+	key, err := stub.CreateCompositeKey(holdIndex, []string{h.ID})
+	if err != nil {
+		return err
+	}
+	holdbytes, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, holdbytes)
+	if err != nil {
+		return err
+	}
+
+	indexKey, err := stub.CreateCompositeKey(accountHoldIndex, []string{h.Account, h.ID})
+	if err != nil {
+		return err
+	}
+	if h.Status == activeHold {
+		return stub.PutState(indexKey, []byte{0x00})
+	}
+	return stub.DelState(indexKey)
+}
+
+// releaseHold gives the amount of a hold back to the balance and the daily
+// total of its account, the caller writes the account
+func releaseHold(stub shim.ChaincodeStubInterface, acc *account, h *hold, status string) error {
+	err := acc.unhold(h.Currency, h.Amount)
+	if err != nil {
+		return err
+	}
+	acc.Balances[h.Currency], err = addAmount(acc.Balances[h.Currency], h.Amount)
+	if err != nil {
+		return err
+	}
+	acc.refundDailyTotal(h.BusinessDay, h.Currency, h.Amount)
+
+	h.Status, h.DecidedBy = status, stub.GetTxID()
+	return putHold(stub, h)
+}
+
+// newHoldChange describes the release of the hold h, acc is its account once released
+func newHoldChange(h *hold, acc *account, currencies *currencies) holdChange {
+	digits := currencies.digits(h.Currency)
+	return holdChange{
+		Hold:     h.ID,
+		Account:  acc.Name,
+		Merchant: h.Merchant,
+		Currency: h.Currency,
+		Amount:   newAmountValue(h.Amount, digits),
+		Balance:  newAmountValue(acc.Balances[h.Currency], digits),
+		Held:     newAmountValue(acc.Held[h.Currency], digits),
+		Expires:  h.Expires,
+		Status:   h.Status,
+	}
+}
+
+// releaseExpiredHolds releases the expired holds of an account about to be
+// debited or closed, the caller writes the account
+func releaseExpiredHolds(stub shim.ChaincodeStubInterface, acc *account, today string) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(accountHoldIndex, []string{acc.Name})
+	if err != nil {
+		return err
+	}
+	defer resultsIterator.Close()
+
+	ids := []string{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return err
+		}
+		_, keys, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, keys[1])
+	}
+
+	for _, id := range ids {
+		h, err := getHold(stub, id)
+		if err != nil {
+			return err
+		}
+		if h.isExpired(today) {
+			err = releaseHold(stub, acc, h, expiredHold)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkMerchant verifies the caller can capture or release a hold: the owner
+// of the merchant account, a teller or a bank admin
+func checkMerchant(stub shim.ChaincodeStubInterface, h *hold, caller *identity) error {
+	if caller.hasRole(roleTeller) || caller.hasRole(roleBankAdmin) {
+		return nil
+	}
+	merchant, err := getAccount(stub, h.Merchant)
+	if err != nil {
+		return err
+	}
+	if !caller.owns(merchant) {
+		return newError(codeNotOwner, details{"account": merchant.Name})
+	}
+	return nil
+}
+
+// placehold holds an amount on an account for a merchant, args are the
+// account, the merchant account, the amount, an optional currency and memo
+func (t *SimpleChaincode) placehold(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	memo := optional(args, 4)
+	err := checkMemo(memo)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currency, err := currencies.resolve(optional(args, 3))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	digits := currencies.digits(currency)
+	X, err := parseAmount(args[2], digits)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if X == 0 {
+		return errorResponse(stub, newError(codeInvalidAmount, details{"amount": args[2]}))
+	}
+
+	if args[0] == args[1] {
+		return errorResponse(stub, newError(codeSameAccount, details{"account": args[0]}))
+	}
+	if args[0] == "MPLBANK" {
+		return errorResponse(stub, newError(codeBankAccount, details{"account": args[0], "function": "placehold"}))
+	}
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if acc.isClosed() {
+		return errorResponse(stub, newError(codeAccountClosed, details{"account": acc.Name}))
+	}
+	if !caller.owns(acc) {
+		return errorResponse(stub, newError(codeNotOwner, details{"account": acc.Name}))
+	}
+	merchant, err := getAccount(stub, args[1])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if merchant.isClosed() {
+		return errorResponse(stub, newError(codeAccountClosed, details{"account": merchant.Name}))
+	}
+
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	_, err = consolidate(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = releaseExpiredHolds(stub, acc, today)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	// a hold counts in the daily total like a move
+	if acc.LastDebitDay != today {
+		acc.TotalsForDay = map[string]uint64{}
+	}
+	limits, err := getLimits(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	totalForDay, err := addAmount(acc.TotalsForDay[currency], X)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if dailyLimit := limits.dailyLimit(acc, currency, digits); totalForDay > dailyLimit {
+		return errorResponse(stub, newError(codeDailyLimitExceeded, details{"account": acc.Name, "amount": args[2], "limit": formatAmount(dailyLimit, digits), "currency": currency}))
+	}
+	balance, err := subAmount(acc.Balances[currency], X)
+	if err != nil {
+		return errorResponse(stub, newError(codeInsufficientFunds, details{"account": acc.Name, "amount": args[2], "currency": currency}))
+	}
+	held, err := addAmount(acc.Held[currency], X)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	acc.TotalsForDay[currency], acc.LastDebitDay = totalForDay, today
+	acc.Balances[currency], acc.Held[currency] = balance, held
+	err = putAccount(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	policy, err := getHoldPolicy(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	start, err := time.Parse(dayLayout, today)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	h := &hold{ObjectType: "HOLD", ID: stub.GetTxID(), Account: acc.Name, Merchant: merchant.Name, Currency: currency, Amount: X, Memo: memo,
+		Requester: caller.key(), BusinessDay: today, Expires: start.AddDate(0, 0, policy.Expiry).Format(dayLayout), Status: activeHold}
+	err = putHold(stub, h)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = setEvent(stub, eventHoldPlaced, holdEvent{
+		eventHeader: newEventHeader(stub, eventHoldPlaced, today, caller),
+		holdChange: holdChange{
+			Hold:     h.ID,
+			Account:  acc.Name,
+			Merchant: merchant.Name,
+			Currency: currency,
+			Amount:   newAmountValue(X, digits),
+			Balance:  newAmountValue(balance, digits),
+			Held:     newAmountValue(held, digits),
+			Expires:  h.Expires,
+			Status:   h.Status,
+		},
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return respond(stub, newHoldResponse(h, currencies))
+}
+
+// capturehold settles a hold, args are the hold ID and the amount credited to
+// the merchant, at most the amount held, the rest goes back to the account
+func (t *SimpleChaincode) capturehold(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	h, err := getHold(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if h.Status != activeHold {
+		return errorResponse(stub, newError(codeHoldNotActive, details{"hold": h.ID, "status": h.Status}))
+	}
+	err = checkMerchant(stub, h, caller)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if h.isExpired(today) {
+		return errorResponse(stub, newError(codeHoldExpired, details{"hold": h.ID, "expires": h.Expires}))
+	}
+
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	digits := currencies.digits(h.Currency)
+	X, err := parseAmount(args[1], digits)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if X == 0 {
+		return errorResponse(stub, newError(codeInvalidAmount, details{"amount": args[1]}))
+	}
+	if X > h.Amount {
+		return errorResponse(stub, newError(codeCaptureExceedsHold, details{"hold": h.ID, "amount": args[1], "held": formatAmount(h.Amount, digits), "currency": h.Currency}))
+	}
+
+	acc, err := getAccount(stub, h.Account)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	_, err = consolidate(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = acc.unhold(h.Currency, h.Amount)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	available, err := addAmount(acc.Balances[h.Currency], h.Amount)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	// the daily total holds the amount captured, the part not captured goes back to it
+	if X < h.Amount {
+		acc.refundDailyTotal(h.BusinessDay, h.Currency, h.Amount-X)
+	}
+	balance, err := subAmount(available, X)
+	if err != nil {
+		return errorResponse(stub, newError(codeInsufficientFunds, details{"account": acc.Name, "amount": args[1], "currency": h.Currency}))
+	}
+	acc.Balances[h.Currency] = balance
+
+	merchant, err := getAccount(stub, h.Merchant)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if merchant.isClosed() {
+		return errorResponse(stub, newError(codeAccountClosed, details{"account": merchant.Name}))
+	}
+	merchantBalance, err := addAmount(merchant.Balances[h.Currency], X)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	merchant.Balances[h.Currency] = merchantBalance
+
+	err = putAccount(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if merchant.Deltas {
+		err = putDelta(stub, merchant.Name, map[string]uint64{h.Currency: X}, nil)
+	} else {
+		err = putAccount(stub, merchant)
+	}
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	tr := newTransfer(stub, acc.Name, merchant.Name, h.Currency, X, today, now, caller)
+	tr.Memo = h.Memo
+	err = putTransfer(stub, tr, now)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	h.Status, h.Captured, h.DecidedBy = capturedHold, X, stub.GetTxID()
+	err = putHold(stub, h)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = setEvent(stub, eventTransfer, transferEvent{
+		eventHeader:   newEventHeader(stub, eventTransfer, today, caller),
+		Debit:         acc.Name,
+		Credit:        merchant.Name,
+		Currency:      h.Currency,
+		Amount:        newAmountValue(X, digits),
+		DebitBalance:  newAmountValue(balance, digits),
+		CreditBalance: newAmountValue(merchantBalance, digits),
+		Memo:          h.Memo,
+		Hold:          h.ID,
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return respond(stub, newHoldResponse(h, currencies))
+}
+
+// releasehold gives the amount of a hold back to its account, args are the hold ID
+func (t *SimpleChaincode) releasehold(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	h, err := getHold(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if h.Status != activeHold {
+		return errorResponse(stub, newError(codeHoldNotActive, details{"hold": h.ID, "status": h.Status}))
+	}
+	err = checkMerchant(stub, h, caller)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	acc, err := getAccount(stub, h.Account)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	_, err = consolidate(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = releaseHold(stub, acc, h, releasedHold)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = putAccount(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = setEvent(stub, eventHoldReleased, holdEvent{
+		eventHeader: newEventHeader(stub, eventHoldReleased, today, caller),
+		holdChange:  newHoldChange(h, acc, currencies),
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return respond(stub, newHoldResponse(h, currencies))
+}
+
+// expireholds releases the expired holds of every account. It emits no
+// event, a transaction has a single one.
+func (t *SimpleChaincode) expireholds(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(accountHoldIndex, []string{})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	defer resultsIterator.Close()
+
+	expired := []*hold{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		_, keys, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		h, err := getHold(stub, keys[1])
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		if h.isExpired(today) {
+			expired = append(expired, h)
+		}
+	}
+
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	// an account is read and written once, a transaction does not read its own writes
+	accounts, names := map[string]*account{}, []string{}
+	released := []holdChange{}
+	for _, h := range expired {
+		acc, read := accounts[h.Account]
+		if !read {
+			acc, err = getAccount(stub, h.Account)
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+			_, err = consolidate(stub, acc)
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+			accounts[h.Account], names = acc, append(names, h.Account)
+		}
+		err = releaseHold(stub, acc, h, expiredHold)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		released = append(released, newHoldChange(h, acc, currencies))
+	}
+	for _, name := range names {
+		err = putAccount(stub, accounts[name])
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+
+	if len(released) > 0 {
+		err = setEvent(stub, eventHoldsExpired, holdsExpiredEvent{
+			eventHeader: newEventHeader(stub, eventHoldsExpired, today, caller),
+			Holds:       released,
+		})
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+	return respond(stub, &expireResponse{Version: responseVersion, Expired: len(expired)})
+}
+
+// setholdexpiry sets the number of business days a hold can be captured, args are the number of days
+func (t *SimpleChaincode) setholdexpiry(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days < 1 {
+		return errorResponse(stub, newError(codeInvalidExpiry, details{"expiry": args[0]}))
+	}
+	policy, err := getHoldPolicy(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	policy.Expiry = days
+
+	policybytes, err := json.Marshal(policy)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = stub.PutState(holdsKey, policybytes)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// checkHold invokes placehold, capturehold or releasehold and verifies the status of the hold
+func checkHold(t *testing.T, stub *shim.MockStub, txid string, status string, function string, args ...string) *holdResponse {
+	bargs := [][]byte{[]byte(function)}
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	res := stub.MockInvoke(txid, bargs)
+	resp := &holdResponse{}
+	if err := json.Unmarshal(res.Payload, resp); err != nil || resp.Status != status {
+		fmt.Println(function, args, "returned", string(res.Payload), res.Message, "instead of", status)
+		t.FailNow()
+	}
+	return resp
+}
+
+func TestHolds_Capture(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+	checkOpen(t, stub, "o1", "BOUTIQUE_1", "Org1MSP", "shop", productMerchant)
+	for _, cn := range []string{"shop", "karine"} {
+		checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte(cn), []byte("customer")})
+	}
+
+	h1 := checkHold(t, stub, "h1", activeHold, "placehold", "COMPTE_JYG", "BOUTIQUE_1", "80", "", "Station service")
+	if h1.ID != "h1" || h1.Amount != "80.00" || h1.Expires == "" {
+		fmt.Println("unexpected hold", h1)
+		t.FailNow()
+	}
+	var placed holdEvent
+	checkEvent(t, stub, eventHoldPlaced, &placed)
+	if placed.Hold != "h1" || placed.Balance.Amount != "1920.00" || placed.Held.Amount != "80.00" || placed.Merchant != "BOUTIQUE_1" {
+		fmt.Println("unexpected HoldPlaced", placed)
+		t.FailNow()
+	}
+	checkHeld(t, stub, "COMPTE_JYG", "1920.00", "80.00")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+
+	// the daily limit counts the holds
+	checkError(t, stub, codeDailyLimitExceeded, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("920.01")})
+	checkError(t, stub, codeFundsHeld, [][]byte{[]byte("closeaccount"), []byte("COMPTE_JYG"), []byte("MPLBANK")})
+
+	// the merchant captures the amount held, not more
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkError(t, stub, codeNotOwner, [][]byte{[]byte("capturehold"), []byte("h1"), []byte("80")})
+	setCreator(t, stub, "Org1MSP", "shop")
+	checkError(t, stub, codeCaptureExceedsHold, [][]byte{[]byte("capturehold"), []byte("h1"), []byte("95.50")})
+	checkError(t, stub, codeCaptureExceedsHold, [][]byte{[]byte("capturehold"), []byte("h1"), []byte("80.01")})
+	checkHeld(t, stub, "COMPTE_JYG", "1920.00", "80.00")
+	h1 = checkHold(t, stub, "c1", capturedHold, "capturehold", "h1", "80")
+	if h1.Captured != "80.00" {
+		fmt.Println("unexpected captured hold", h1)
+		t.FailNow()
+	}
+	var captured transferEvent
+	checkEvent(t, stub, eventTransfer, &captured)
+	if captured.Hold != "h1" || captured.DebitBalance.Amount != "1920.00" || captured.CreditBalance.Amount != "80.00" || captured.Memo != "Station service" {
+		fmt.Println("unexpected Transfer", captured)
+		t.FailNow()
+	}
+	checkError(t, stub, codeHoldNotActive, [][]byte{[]byte("capturehold"), []byte("h1"), []byte("80")})
+
+	// a released hold and the part of a hold not captured go back to the daily total
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkHold(t, stub, "h2", activeHold, "placehold", "COMPTE_JYG", "BOUTIQUE_1", "50")
+	checkHold(t, stub, "h3", activeHold, "placehold", "COMPTE_JYG", "BOUTIQUE_1", "200")
+	setCreator(t, stub, "Org1MSP", "shop")
+	checkHold(t, stub, "r2", releasedHold, "releasehold", "h2")
+	var released holdEvent
+	checkEvent(t, stub, eventHoldReleased, &released)
+	if released.Hold != "h2" || released.Balance.Amount != "1720.00" || released.Held.Amount != "200.00" || released.Status != releasedHold {
+		fmt.Println("unexpected HoldReleased", released)
+		t.FailNow()
+	}
+	checkHold(t, stub, "c3", capturedHold, "capturehold", "h3", "150")
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkHeld(t, stub, "COMPTE_JYG", "1770.00", "")
+	checkMove(t, stub, "t3", "COMPTE_JYG", "COMPTE_KARINE", "770")
+	checkError(t, stub, codeDailyLimitExceeded, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("0.01")})
+	checkBalance(t, stub, "BOUTIQUE_1", "230.00")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+
+	for code, args := range map[string][]string{
+		codeBankAccount:     {"placehold", "MPLBANK", "BOUTIQUE_1", "10"},
+		codeSameAccount:     {"placehold", "COMPTE_KARINE", "COMPTE_KARINE", "10"},
+		codeInvalidAmount:   {"placehold", "COMPTE_KARINE", "BOUTIQUE_1", "0"},
+		codeAccountNotFound: {"placehold", "COMPTE_KARINE", "BOUTIQUE_INCONNUE", "10"},
+		codeHoldNotFound:    {"releasehold", "h9"},
+	} {
+		bargs := [][]byte{}
+		for _, a := range args {
+			bargs = append(bargs, []byte(a))
+		}
+		checkError(t, stub, code, bargs)
+	}
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkError(t, stub, codeNotOwner, [][]byte{[]byte("placehold"), []byte("COMPTE_JYG"), []byte("BOUTIQUE_1"), []byte("10")})
+}
+
+func TestHolds_Expiry(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+	checkError(t, stub, codeInvalidExpiry, [][]byte{[]byte("setholdexpiry"), []byte("0")})
+	checkInvoke(t, stub, [][]byte{[]byte("setholdexpiry"), []byte("1")})
+
+	checkHold(t, stub, "h1", activeHold, "placehold", "COMPTE_KARINE", "COMPTE_JYG", "30")
+	checkHeld(t, stub, "COMPTE_KARINE", "70.00", "30.00")
+
+	// an expired hold cannot be captured, the next debit of its account releases it
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	checkError(t, stub, codeHoldExpired, [][]byte{[]byte("capturehold"), []byte("h1"), []byte("30")})
+	checkMove(t, stub, "t3", "COMPTE_KARINE", "COMPTE_JYG", "10")
+	checkHeld(t, stub, "COMPTE_KARINE", "90.00", "")
+	checkError(t, stub, codeHoldNotActive, [][]byte{[]byte("releasehold"), []byte("h1")})
+
+	// or expireholds, every account read once
+	checkHold(t, stub, "h2", activeHold, "placehold", "COMPTE_KARINE", "COMPTE_JYG", "20")
+	checkHold(t, stub, "h3", activeHold, "placehold", "COMPTE_KARINE", "COMPTE_JYG", "25")
+	checkHold(t, stub, "h4", activeHold, "placehold", "COMPTE_JYG", "COMPTE_KARINE", "5")
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	res := stub.MockInvoke("1", [][]byte{[]byte("expireholds")})
+	if string(res.Payload) != `{"version":1,"expired":3}` {
+		fmt.Println("expireholds returned", string(res.Payload), res.Message)
+		t.FailNow()
+	}
+	var expired holdsExpiredEvent
+	checkEvent(t, stub, eventHoldsExpired, &expired)
+	if len(expired.Holds) != 3 || expired.Holds[0].Hold != "h4" || expired.Holds[0].Balance.Amount != "2010.00" ||
+		expired.Holds[2].Hold != "h3" || expired.Holds[2].Balance.Amount != "90.00" || expired.Holds[2].Held.Amount != "0.00" || expired.Holds[2].Status != expiredHold {
+		fmt.Println("unexpected HoldsExpired", expired)
+		t.FailNow()
+	}
+	checkHeld(t, stub, "COMPTE_KARINE", "90.00", "")
+	checkHeld(t, stub, "COMPTE_JYG", "2010.00", "")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+	checkInvoke(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_KARINE"), []byte("COMPTE_JYG")})
+}
@@ -0,0 +1,252 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// A client that gets no answer cannot tell whether its move committed. It
+// can give the move an idempotency key: the idempotency~key index keeps,
+// per debit account, the parameters and the result of the move that used
+// it, so that a retry returns that result instead of paying twice. A key
+// expires some business days after its move and can then be used again.
+const idempotencyIndex = "idempotency~key" // debit account, key
+
+// retention is kept in world state so it can change without a redeploy
+const idempotencyKey = "MPLBANK_IDEMPOTENCY"
+
+// business days a key is kept when the ledger holds no retention record yet
+const defaultRetention = 7
+
+// keys are meant for UUIDs and similar client generated identifiers
+const maxIdempotencyKeyLength = 64
+
+var idempotencyChars = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+type idempotencyConfig struct {
+	ObjectType string `json:"docType"`
+	Retention  int    `json:"retention"` // business days a key is kept after the day of its move
+}
+
+// idempotentMove is the record of a move made with an idempotency key
+type idempotentMove struct {
+	ObjectType  string `json:"docType"`
+	Key         string `json:"key"`
+	TxID        string `json:"txid"`
+	BusinessDay string `json:"businessday"`
+	Debit       string `json:"debit"`
+	Credit      string `json:"credit"`
+	Currency    string `json:"currency"`
+	Amount      uint64 `json:"amount"` // minor units
+	Memo        string `json:"memo,omitempty"`
+	Reference   string `json:"reference,omitempty"`
+	Result      []byte `json:"result"` // payload of the move
+}
+
+// purgeResponse is the result of purgeidempotencykeys
+type purgeResponse struct {
+	Version int `json:"version"`
+	Purged  int `json:"purged"` // number of expired keys deleted
+}
+
+func newIdempotencyConfig() *idempotencyConfig {
+	return &idempotencyConfig{ObjectType: "IDEMPOTENCY", Retention: defaultRetention}
+}
+
+func getIdempotencyConfig(stub shim.ChaincodeStubInterface) (*idempotencyConfig, error) {
+	configbytes, err := stub.GetState(idempotencyKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", idempotencyKey)
+	}
+	config := newIdempotencyConfig()
+	if configbytes == nil {
+		return config, nil
+	}
+	err = json.Unmarshal(configbytes, config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", idempotencyKey)
+	}
+	return config, nil
+}
+
+func putIdempotencyConfig(stub shim.ChaincodeStubInterface, config *idempotencyConfig) error {
+	configbytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(idempotencyKey, configbytes)
+}
+
+// expired tells whether a key used on day can be used again on today
+func (c *idempotencyConfig) expired(day string, today string) bool {
+	used, err := time.Parse(dayLayout, day)
+	if err != nil {
+		return true
+	}
+	now, err := time.Parse(dayLayout, today)
+	if err != nil {
+		return false
+	}
+	return !now.Before(used.AddDate(0, 0, c.Retention))
+}
+
+// checkIdempotencyKey validates the characters of a key, an empty key is no key
+func checkIdempotencyKey(key string) error {
+	if key == "" {
+		return nil
+	}
+	if len(key) > maxIdempotencyKeyLength || !idempotencyChars.MatchString(key) {
+		return newError(codeInvalidIdempotency, details{"key": key, "max": strconv.Itoa(maxIdempotencyKeyLength)})
+	}
+	return nil
+}
+
+// sameMove tells whether a retry has the parameters of the recorded move
+func (m *idempotentMove) sameMove(other *idempotentMove) bool {
+	return m.Debit == other.Debit && m.Credit == other.Credit && m.Currency == other.Currency &&
+		m.Amount == other.Amount && m.Memo == other.Memo && m.Reference == other.Reference
+}
+
+// getIdempotentMove returns the move recorded under the key of the debit
+// account, nil when the key is unused or expired
+func getIdempotentMove(stub shim.ChaincodeStubInterface, debit string, key string, today string) (*idempotentMove, error) {
+	compositeKey, err := stub.CreateCompositeKey(idempotencyIndex, []string{debit, key})
+	if err != nil {
+		return nil, err
+	}
+	movebytes, err := stub.GetState(compositeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for idempotency key %s", key)
+	}
+	if movebytes == nil {
+		return nil, nil
+	}
+	m := &idempotentMove{}
+	err = json.Unmarshal(movebytes, m)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of idempotency key %s", key)
+	}
+
+	config, err := getIdempotencyConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	if config.expired(m.BusinessDay, today) {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// checkRetry returns the result of the move recorded under the key when the
+// retry has the same parameters, nil when the key is unused or expired
+func checkRetry(stub shim.ChaincodeStubInterface, retry *idempotentMove, today string) ([]byte, error) {
+	m, err := getIdempotentMove(stub, retry.Debit, retry.Key, today)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	if !m.sameMove(retry) {
+		return nil, newError(codeIdempotencyReused, details{"key": m.Key, "account": m.Debit, "businessday": m.BusinessDay, "txid": m.TxID})
+	}
+	return m.Result, nil
+}
+
+func putIdempotentMove(stub shim.ChaincodeStubInterface, m *idempotentMove) error {
+	compositeKey, err := stub.CreateCompositeKey(idempotencyIndex, []string{m.Debit, m.Key})
+	if err != nil {
+		return err
+	}
+	movebytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(compositeKey, movebytes)
+}
+
+// setidempotencyretention sets the number of business days an idempotency
+// key is kept after the day of its move, args are the number of days
+func (t *SimpleChaincode) setidempotencyretention(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days < 1 {
+		return errorResponse(stub, newError(codeInvalidRetention, details{"retention": args[0]}))
+	}
+
+	config, err := getIdempotencyConfig(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	config.Retention = days
+	err = putIdempotencyConfig(stub, config)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
+
+// purgeidempotencykeys deletes the expired idempotency keys, of every
+// account or of the optional account given in args
+func (t *SimpleChaincode) purgeidempotencykeys(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	config, err := getIdempotencyConfig(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	attributes := []string{}
+	if name := optional(args, 0); name != "" {
+		attributes = append(attributes, name)
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(idempotencyIndex, attributes)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	defer resultsIterator.Close()
+
+	resp := &purgeResponse{Version: responseVersion}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		m := &idempotentMove{}
+		err = json.Unmarshal(kv.Value, m)
+		if err != nil {
+			return errorResponse(stub, fmt.Errorf("Failed to decode JSON of: %s", kv.Key))
+		}
+		if !config.expired(m.BusinessDay, today) {
+			continue
+		}
+		err = stub.DelState(kv.Key)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		resp.Purged++
+	}
+	return respond(stub, resp)
+}
@@ -0,0 +1,101 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkBalance(t *testing.T, stub *shim.MockStub, name string, expected string) {
+	res := stub.MockInvoke("1", [][]byte{[]byte("query"), []byte(name)})
+	var acc accountResponse
+	if err := json.Unmarshal(res.Payload, &acc); err != nil || acc.Balance != expected {
+		fmt.Println("balance of", name, "is", string(res.Payload), "instead of", expected)
+		t.FailNow()
+	}
+}
+
+func checkPurge(t *testing.T, stub *shim.MockStub, expected int, args ...string) {
+	bargs := [][]byte{[]byte("purgeidempotencykeys")}
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	res := stub.MockInvoke("1", bargs)
+	var resp purgeResponse
+	if err := json.Unmarshal(res.Payload, &resp); err != nil || resp.Purged != expected {
+		fmt.Println("purgeidempotencykeys returned", string(res.Payload), res.Message, "instead of", expected)
+		t.FailNow()
+	}
+}
+
+func TestIdempotency_Retry(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+
+	checkMove(t, stub, "t3", "COMPTE_JYG", "COMPTE_KARINE", "10", "", "", "INV-42", "6f1c2a9e-k1")
+	checkBalance(t, stub, "COMPTE_JYG", "1990.00")
+
+	// the retry returns the first result, it is neither paid again nor rejected as a replayed reference
+	res := stub.MockInvoke("t4", [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10.00"), []byte(""), []byte(""), []byte("INV-42"), []byte("6f1c2a9e-k1")})
+	if res.Status != shim.OK || string(res.Payload) != "OK" {
+		fmt.Println("retry failed", res.Message)
+		t.FailNow()
+	}
+	checkBalance(t, stub, "COMPTE_JYG", "1990.00")
+	checkError(t, stub, codeTransferNotFound, [][]byte{[]byte("gettransfer"), []byte("t4")})
+
+	e := checkError(t, stub, codeIdempotencyReused, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("11"), []byte(""), []byte(""), []byte("INV-42"), []byte("6f1c2a9e-k1")})
+	if e.Fields["txid"] != "t3" {
+		fmt.Println("reused key does not name the first transaction", e)
+		t.FailNow()
+	}
+	for _, key := range []string{"6f1c 2a9e", "clé", strings.Repeat("a", maxIdempotencyKeyLength+1)} {
+		checkError(t, stub, codeInvalidIdempotency, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10"), []byte(""), []byte(""), []byte(""), []byte(key)})
+	}
+
+	// keys belong to the debit account
+	checkMove(t, stub, "t5", "COMPTE_KARINE", "COMPTE_JYG", "5", "", "", "", "6f1c2a9e-k1")
+	checkBalance(t, stub, "COMPTE_JYG", "1995.00")
+
+	checkError(t, stub, codeInvalidRetention, [][]byte{[]byte("setidempotencyretention"), []byte("0")})
+	checkInvoke(t, stub, [][]byte{[]byte("setidempotencyretention"), []byte("2")})
+
+	// kept the next day, expired the day after
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	checkError(t, stub, codeIdempotencyReused, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("11"), []byte(""), []byte(""), []byte(""), []byte("6f1c2a9e-k1")})
+	checkPurge(t, stub, 0)
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	checkMove(t, stub, "t6", "COMPTE_JYG", "COMPTE_KARINE", "11", "", "", "", "6f1c2a9e-k1")
+	checkBalance(t, stub, "COMPTE_JYG", "1984.00")
+
+	checkPurge(t, stub, 0, "COMPTE_JYG")
+	checkPurge(t, stub, 1, "COMPTE_KARINE")
+	checkInvoke(t, stub, [][]byte{[]byte("setidempotencyretention"), []byte("1")})
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	checkPurge(t, stub, 1)
+	checkPurge(t, stub, 0)
+}
@@ -0,0 +1,261 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// roles a caller can hold, roleAnyone is only used in function declarations
+const (
+	roleBankAdmin = "bankadmin"
+	roleTeller    = "teller"
+	roleAuditor   = "auditor"
+	roleApprover  = "approver"
+	roleCustomer  = "customer"
+	roleAnyone    = "anyone"
+)
+
+var knownRoles = []string{roleBankAdmin, roleTeller, roleAuditor, roleApprover, roleCustomer}
+
+// role assignments are stored under role~identity composite keys
+const roleIndex = "role~identity"
+
+// the MSP of the identity deploying the chaincode, accounts opened before
+// identities carried the MSP ID belong to the common names of that MSP
+const deployerKey = "MPLBANK_DEPLOYER"
+
+type deployer struct {
+	ObjectType string `json:"docType"`
+	MSPID      string `json:"mspid"`
+}
+
+// identity of a caller, taken from the certificate that signed the proposal
+type identity struct {
+	MSPID  string   `json:"mspid"`
+	CN     string   `json:"cn"` // common name of the certificate subject
+	Roles  []string `json:"roles"`
+	legacy bool     // the MSP deployed the chaincode, the caller owns the accounts of its bare common name
+}
+
+// key identifies the caller across MSPs, it is used as account owner
+func (id *identity) key() string {
+	return id.MSPID + "/" + id.CN
+}
+
+func (id *identity) hasRole(role string) bool {
+	for _, r := range id.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// owns tells whether the caller is the owner of the account. Accounts opened
+// before identities carried the MSP ID are owned by a bare common name, of
+// the MSP that deployed the chaincode: the same name in another MSP is
+// another identity.
+func (id *identity) owns(acc *account) bool {
+	if acc.Owner == id.key() {
+		return true
+	}
+	return id.legacy && !strings.Contains(acc.Owner, "/") && acc.Owner == id.CN
+}
+
+// reads tells whether the caller may read the moves of the account: its
+// owner, an auditor or a bank administrator
+func (id *identity) reads(acc *account) bool {
+	return id.owns(acc) || id.hasRole(roleAuditor) || id.hasRole(roleBankAdmin)
+}
+
+// getIdentity deserializes the creator of the transaction and loads its roles
+func getIdentity(stub shim.ChaincodeStubInterface) (*identity, error) {
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return nil, newError(codeInvalidIdentity, details{"detail": "failed to get creator"})
+	}
+
+	sid := &msp.SerializedIdentity{}
+	err = proto.Unmarshal(creator, sid)
+	if err != nil {
+		return nil, newError(codeInvalidIdentity, details{"detail": "failed to deserialize creator"})
+	}
+
+	block, _ := pem.Decode(sid.IdBytes)
+	if block == nil {
+		return nil, newError(codeInvalidIdentity, details{"detail": "failed to parse certificate PEM"})
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, newError(codeInvalidIdentity, details{"detail": "failed to parse certificate: " + err.Error()})
+	}
+
+	id := &identity{MSPID: sid.Mspid, CN: cert.Subject.CommonName}
+	if id.MSPID == "" || id.CN == "" {
+		return nil, newError(codeInvalidIdentity, details{"detail": "creator has no MSP ID or no common name"})
+	}
+
+	id.Roles, err = getRoles(stub, id.MSPID, id.CN)
+	if err != nil {
+		return nil, err
+	}
+	d, err := getDeployer(stub)
+	if err != nil {
+		return nil, err
+	}
+	id.legacy = d != nil && d.MSPID == id.MSPID
+	return id, nil
+}
+
+// getDeployer returns the MSP that deployed the chaincode, nil before Init
+func getDeployer(stub shim.ChaincodeStubInterface) (*deployer, error) {
+	deployerbytes, err := stub.GetState(deployerKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", deployerKey)
+	}
+	if deployerbytes == nil {
+		return nil, nil
+	}
+	d := &deployer{}
+	err = json.Unmarshal(deployerbytes, d)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", deployerKey)
+	}
+	return d, nil
+}
+
+func putDeployer(stub shim.ChaincodeStubInterface, mspid string) error {
+	deployerbytes, err := json.Marshal(&deployer{ObjectType: "DEPLOYER", MSPID: mspid})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(deployerKey, deployerbytes)
+}
+
+func getRoles(stub shim.ChaincodeStubInterface, mspid string, cn string) ([]string, error) {
+	key, err := stub.CreateCompositeKey(roleIndex, []string{mspid, cn})
+	if err != nil {
+		return nil, err
+	}
+	rolesbytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get roles of %s/%s", mspid, cn)
+	}
+	roles := []string{}
+	if rolesbytes == nil {
+		return roles, nil
+	}
+	err = json.Unmarshal(rolesbytes, &roles)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode roles of %s/%s", mspid, cn)
+	}
+	return roles, nil
+}
+
+func putRoles(stub shim.ChaincodeStubInterface, mspid string, cn string, roles []string) error {
+	key, err := stub.CreateCompositeKey(roleIndex, []string{mspid, cn})
+	if err != nil {
+		return err
+	}
+	if len(roles) == 0 {
+		return stub.DelState(key)
+	}
+	sort.Strings(roles)
+	rolesbytes, err := json.Marshal(roles)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, rolesbytes)
+}
+
+func isKnownRole(role string) bool {
+	for _, r := range knownRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// grantrole gives a role to the identity mspid/cn
+func (t *SimpleChaincode) grantrole(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+	mspid, cn, role := args[0], args[1], args[2]
+	if !isKnownRole(role) {
+		return errorResponse(stub, newError(codeUnknownRole, details{"role": role}))
+	}
+
+	roles, err := getRoles(stub, mspid, cn)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	for _, r := range roles {
+		if r == role {
+			return shim.Success(nil)
+		}
+	}
+
+	err = putRoles(stub, mspid, cn, append(roles, role))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
+
+// revokerole removes a role from the identity mspid/cn
+func (t *SimpleChaincode) revokerole(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+	mspid, cn, role := args[0], args[1], args[2]
+	if role == roleBankAdmin && mspid == caller.MSPID && cn == caller.CN {
+		return errorResponse(stub, newError(codeOwnAdminRole, details{"mspid": mspid, "cn": cn}))
+	}
+
+	roles, err := getRoles(stub, mspid, cn)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	kept := []string{}
+	for _, r := range roles {
+		if r != role {
+			kept = append(kept, r)
+		}
+	}
+
+	err = putRoles(stub, mspid, cn, kept)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
+
+// whoami returns the identity and the roles of the caller
+func (t *SimpleChaincode) whoami(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+	callerbytes, err := json.Marshal(caller)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(callerbytes)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkRoles(t *testing.T, stub *shim.MockStub, roles ...string) {
+	res := stub.MockInvoke("1", [][]byte{[]byte("whoami")})
+	if res.Status != shim.OK {
+		fmt.Println("whoami failed", res.Message)
+		t.FailNow()
+	}
+	var id identity
+	if err := json.Unmarshal(res.Payload, &id); err != nil {
+		fmt.Println("whoami returned invalid JSON", err)
+		t.FailNow()
+	}
+	if fmt.Sprint(id.Roles) != fmt.Sprint(roles) {
+		fmt.Println("roles of", id.key(), "were", id.Roles, "instead of", roles)
+		t.FailNow()
+	}
+}
+
+func TestIdentity_Roles(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+
+	// a creator that is not a serialized identity is rejected
+	stub.Creator = []byte("jyg")
+	res := stub.MockInit("1", [][]byte{[]byte("init"), []byte("900000000")})
+	if res.Status == shim.OK {
+		fmt.Println("Init accepted an invalid creator")
+		t.FailNow()
+	}
+
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_FABIEN")
+	checkRoles(t, stub, roleBankAdmin)
+
+	// the same common name in another MSP is another identity
+	setCreator(t, stub, "Org2MSP", "jyg")
+	checkRoles(t, stub)
+	checkInvokeFails(t, stub, [][]byte{[]byte("getaccounts")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
+
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkInvokeFails(t, stub, [][]byte{[]byte("grantrole"), []byte("Org2MSP"), []byte("jyg"), []byte("pope")})
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org2MSP"), []byte("karine"), []byte("customer")})
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org2MSP"), []byte("estelle"), []byte("auditor")})
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org2MSP"), []byte("fabien"), []byte("teller")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("revokerole"), []byte("Org1MSP"), []byte("jyg"), []byte("bankadmin")})
+
+	// a teller can open an account but not change the limits
+	setCreator(t, stub, "Org2MSP", "fabien")
+	checkRoles(t, stub, roleTeller)
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_FABIEN"), []byte("2000")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("setlimit"), []byte("default"), []byte(""), []byte("daily"), []byte("500")})
+
+	// an auditor can list accounts but cannot move money
+	setCreator(t, stub, "Org2MSP", "estelle")
+	checkInvoke(t, stub, [][]byte{[]byte("getaccounts")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_FABIEN"), []byte("MPLBANK"), []byte("10")})
+
+	// a customer cannot spend from an account it does not own
+	setCreator(t, stub, "Org2MSP", "karine")
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_FABIEN"), []byte("MPLBANK"), []byte("10")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_FABIEN"), []byte("MPLBANK")})
+
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkInvoke(t, stub, [][]byte{[]byte("revokerole"), []byte("Org2MSP"), []byte("karine"), []byte("customer")})
+	setCreator(t, stub, "Org2MSP", "karine")
+	checkRoles(t, stub)
+}
@@ -0,0 +1,293 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// limits are kept in world state so they can change without a redeploy
+const limitsKey = "MPLBANK_LIMITS"
+
+// values used when the ledger holds no limits record yet, in major units
+const (
+	defaultDailyLimit     uint64 = 1000
+	defaultOpeningLimit   uint64 = 10000
+	defaultTopupLimit     uint64 = 5000
+	defaultBankTopupLimit uint64 = 100000
+)
+
+// anyCurrency is the currency key of a limit that applies to every currency
+const anyCurrency = "*"
+
+// amounts holds a limit per currency code, in minor units of the currency.
+// The limit for any currency is in major units since the scale differs
+// between currencies. Records written before limits were per currency hold
+// a bare number, read as a limit for any currency.
+type amounts map[string]uint64
+
+func (a *amounts) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var n uint64
+	if json.Unmarshal(data, &n) == nil {
+		*a = amounts{anyCurrency: n}
+		return nil
+	}
+	var m map[string]uint64
+	err := json.Unmarshal(data, &m)
+	if err != nil {
+		return err
+	}
+	*a = amounts(m)
+	return nil
+}
+
+// get returns the limit of the currency in minor units, or the one for any currency
+func (a amounts) get(currency string, digits int) (uint64, bool) {
+	if v, ok := a[currency]; ok {
+		return v, true
+	}
+	v, ok := a[anyCurrency]
+	if !ok {
+		return 0, false
+	}
+	if v > math.MaxUint64/pow10(digits) {
+		return math.MaxUint64, true
+	}
+	return v * pow10(digits), true
+}
+
+// limit holds the values of one scope, a missing currency means not overridden
+type limit struct {
+	Daily    amounts `json:"daily,omitempty"`
+	Opening  amounts `json:"opening,omitempty"`
+	Topup    amounts `json:"topup,omitempty"`    // top-ups an account may receive per day
+	Approval amounts `json:"approval,omitempty"` // moves above it wait for approvers, none when missing or zero
+}
+
+type limitsConfig struct {
+	ObjectType string           `json:"docType"`
+	Default    limit            `json:"default"`
+	Tiers      map[string]limit `json:"tiers"`
+	Accounts   map[string]limit `json:"accounts"`
+	BankTopup  amounts          `json:"banktopup,omitempty"` // top-ups the bank may make per day, all accounts together
+}
+
+func newLimitsConfig() *limitsConfig {
+	return &limitsConfig{
+		ObjectType: "LIMITS",
+		Default:    limit{Daily: amounts{anyCurrency: defaultDailyLimit}, Opening: amounts{anyCurrency: defaultOpeningLimit}, Topup: amounts{anyCurrency: defaultTopupLimit}},
+		Tiers:      map[string]limit{},
+		Accounts:   map[string]limit{},
+		BankTopup:  amounts{anyCurrency: defaultBankTopupLimit},
+	}
+}
+
+func getLimits(stub shim.ChaincodeStubInterface) (*limitsConfig, error) {
+	limitsbytes, err := stub.GetState(limitsKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", limitsKey)
+	}
+	config := newLimitsConfig()
+	if limitsbytes == nil {
+		return config, nil
+	}
+	err = json.Unmarshal(limitsbytes, config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", limitsKey)
+	}
+	return config, nil
+}
+
+func putLimits(stub shim.ChaincodeStubInterface, config *limitsConfig) error {
+	limitsbytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(limitsKey, limitsbytes)
+}
+
+// resolve picks the most specific value: account, then tier, then default
+func (c *limitsConfig) resolve(name string, tier string, currency string, digits int, get func(limit) amounts) uint64 {
+	if v, ok := get(c.Accounts[name]).get(currency, digits); ok {
+		return v
+	}
+	if tier != "" {
+		if v, ok := get(c.Tiers[tier]).get(currency, digits); ok {
+			return v
+		}
+	}
+	v, _ := get(c.Default).get(currency, digits)
+	return v
+}
+
+// dailyLimit is the effective amount, in minor units, an account may transfer per day in a currency
+func (c *limitsConfig) dailyLimit(acc *account, currency string, digits int) uint64 {
+	return c.resolve(acc.Name, acc.Tier, currency, digits, func(l limit) amounts { return l.Daily })
+}
+
+// openingLimit is the effective maximum, in minor units, credited by the bank when an account is opened
+func (c *limitsConfig) openingLimit(acc *account, currency string, digits int) uint64 {
+	return c.resolve(acc.Name, acc.Tier, currency, digits, func(l limit) amounts { return l.Opening })
+}
+
+// topupLimit is the effective amount, in minor units, tellers may top an account up with per day
+func (c *limitsConfig) topupLimit(acc *account, currency string, digits int) uint64 {
+	return c.resolve(acc.Name, acc.Tier, currency, digits, func(l limit) amounts { return l.Topup })
+}
+
+// approvalThreshold is the amount, in minor units, above which a move of the account waits for approvers, 0 when none
+func (c *limitsConfig) approvalThreshold(acc *account, currency string, digits int) uint64 {
+	return c.resolve(acc.Name, acc.Tier, currency, digits, func(l limit) amounts { return l.Approval })
+}
+
+// bankTopupLimit is the amount, in minor units, tellers may top all accounts up with per day
+func (c *limitsConfig) bankTopupLimit(currency string, digits int) uint64 {
+	v, _ := c.BankTopup.get(currency, digits)
+	return v
+}
+
+// setlimit changes a limit: scope is default, tier, account or bank, target
+// is the tier or existing account name (ignored for default and bank), kind is daily,
+// opening, topup or approval. The bank scope only has the topup kind, the
+// top-ups of all accounts together. Without a currency the limit applies to
+// every currency and is a whole number of major units.
+func (t *SimpleChaincode) setlimit(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	scope, target, kind := args[0], args[1], args[2]
+	currency := anyCurrency
+	digits := 0
+	if len(args) > 4 && args[4] != "" {
+		c, err := getCurrencies(stub)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		currency, err = c.resolve(args[4])
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		digits = c.digits(currency)
+	}
+	value, err := parseAmount(args[3], digits)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	config, err := getLimits(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	if scope == "bank" {
+		if kind != "topup" {
+			return errorResponse(stub, newError(codeInvalidLimitKind, details{"kind": kind, "scope": scope, "kinds": "topup"}))
+		}
+		if config.BankTopup == nil {
+			config.BankTopup = amounts{}
+		}
+		config.BankTopup[currency] = value
+		err = putLimits(stub, config)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		return shim.Success(nil)
+	}
+
+	var l limit
+	switch scope {
+	case "default":
+		l = config.Default
+	case "tier":
+		l = config.Tiers[target]
+	case "account":
+		l = config.Accounts[target]
+	default:
+		return errorResponse(stub, newError(codeInvalidLimitScope, details{"scope": scope}))
+	}
+	if scope != "default" && target == "" {
+		return errorResponse(stub, newError(codeTargetRequired, details{"scope": scope}))
+	}
+	if scope == "account" {
+		_, err = getAccount(stub, target)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+
+	switch kind {
+	case "daily":
+		if l.Daily == nil {
+			l.Daily = amounts{}
+		}
+		l.Daily[currency] = value
+	case "opening":
+		if l.Opening == nil {
+			l.Opening = amounts{}
+		}
+		l.Opening[currency] = value
+	case "topup":
+		if l.Topup == nil {
+			l.Topup = amounts{}
+		}
+		l.Topup[currency] = value
+	case "approval":
+		if l.Approval == nil {
+			l.Approval = amounts{}
+		}
+		l.Approval[currency] = value
+	default:
+		return errorResponse(stub, newError(codeInvalidLimitKind, details{"kind": kind, "scope": scope, "kinds": "daily, opening, topup, approval"}))
+	}
+
+	switch scope {
+	case "default":
+		config.Default = l
+	case "tier":
+		config.Tiers[target] = l
+	case "account":
+		config.Accounts[target] = l
+	}
+
+	err = putLimits(stub, config)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
+
+// settier places an account in a customer tier
+func (t *SimpleChaincode) settier(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	acc.Tier = args[1]
+
+	err = putAccount(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
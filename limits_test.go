@@ -0,0 +1,66 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestLimits_SetLimit(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_GOLD", "COMPTE_KARINE")
+
+	// only the bank owner may change the limits
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkInvokeFails(t, stub, [][]byte{[]byte("setlimit"), []byte("default"), []byte(""), []byte("daily"), []byte("500")})
+
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkInvokeFails(t, stub, [][]byte{[]byte("setlimit"), []byte("planet"), []byte(""), []byte("daily"), []byte("500")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("setlimit"), []byte("tier"), []byte(""), []byte("daily"), []byte("500")})
+
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("default"), []byte(""), []byte("opening"), []byte("20000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("15000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_GOLD"), []byte("15000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("100")})
+
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("default"), []byte(""), []byte("daily"), []byte("500")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("600")})
+
+	// the account override wins over the default, it needs an existing account
+	checkError(t, stub, codeAccountNotFound, [][]byte{[]byte("setlimit"), []byte("account"), []byte("COMPTE_JGY"), []byte("daily"), []byte("2000")})
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("account"), []byte("COMPTE_JYG"), []byte("daily"), []byte("2000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("600")})
+
+	// the tier override applies to the accounts of the tier
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("tier"), []byte("gold"), []byte("daily"), []byte("5000")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_GOLD"), []byte("COMPTE_KARINE"), []byte("3000")})
+	checkInvoke(t, stub, [][]byte{[]byte("settier"), []byte("COMPTE_GOLD"), []byte("gold")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_GOLD"), []byte("COMPTE_KARINE"), []byte("3000")})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("queryplafond"), []byte("COMPTE_GOLD")})
+	if res.Status != shim.OK || !strings.Contains(string(res.Payload), `"limit":"5000.00"`) {
+		fmt.Println("queryplafond did not report the tier limit", string(res.Payload), res.Message)
+		t.FailNow()
+	}
+}
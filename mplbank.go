@@ -16,18 +16,17 @@ limitations under the License.
 
 package main
 
-
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
-	"encoding/json"
-	"bytes"
-	"regexp"
-	"crypto/x509"
-    "encoding/pem"
+	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric/core/chaincode/shim"
-	 pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
 )
 
 // SimpleChaincode example simple Chaincode implementation
@@ -35,536 +34,1010 @@ type SimpleChaincode struct {
 }
 
 type account struct {
-	ObjectType        string `json:"docType"` //docType is used to distinguish the various types of objects in state database
-	Name       		  string `json:"name"`    //the fieldtags are needed to keep case from bouncing around
-    CurrentBalance    uint64 `json:"currentbalance"`
-	TotalForDay       uint64 `json:"totalforday"`
-	CurrentDay        uint64 `json:"currentday"`
-	Owner             string `json:"owner"`
+	ObjectType     string            `json:"docType"`                  //docType is used to distinguish the various types of objects in state database
+	Name           string            `json:"name"`                     //the fieldtags are needed to keep case from bouncing around
+	Balances       map[string]uint64 `json:"balances"`                 //amount held per ISO-4217 currency code
+	Held           map[string]uint64 `json:"held,omitempty"`           //amount reserved per currency by pending transfers and holds, not in Balances
+	CurrentBalance uint64            `json:"currentbalance,omitempty"` //single balance of older records, read as the default currency
+	TotalsForDay   map[string]uint64 `json:"totalsforday"`             //amount debited per currency on LastDebitDay
+	TotalForDay    uint64            `json:"totalforday,omitempty"`    //single total of older records, read as the default currency
+	LastDebitDay   string            `json:"lastdebitday,omitempty"`   //business day of the last debit, TotalsForDay belongs to it
+	Owner          string            `json:"owner"`
+	Tier           string            `json:"tier,omitempty"`
+	Product        string            `json:"product,omitempty"` //product type given by openaccount, empty for accounts opened before it
+	Status         string            `json:"status,omitempty"`  //empty for accounts opened before closure existed, they are open
+	Deltas         bool              `json:"deltas,omitempty"`  //balance changes are appended as delta records, see deltas.go
+}
+
+// account status
+const (
+	statusOpen   = "OPEN"
+	statusClosed = "CLOSED"
+)
+
+func (acc *account) isClosed() bool {
+	return acc.Status == statusClosed
+}
+
+// normalize moves the single balance of older records to the default currency.
+// Those balances were whole units, they are scaled to the minor units of the currency.
+// Records older than closure have no status, they are open.
+func (acc *account) normalize(c *currencies) error {
+	def := c.Default
+	if acc.Balances == nil {
+		acc.Balances = map[string]uint64{}
+	}
+	if acc.TotalsForDay == nil {
+		acc.TotalsForDay = map[string]uint64{}
+	}
+	if acc.Held == nil {
+		acc.Held = map[string]uint64{}
+	}
+	if acc.Status == "" {
+		acc.Status = statusOpen
+	}
+	if acc.CurrentBalance > 0 {
+		balance, err := scaleAmount(acc.CurrentBalance, c.digits(def))
+		if err != nil {
+			return err
+		}
+		acc.Balances[def], err = addAmount(acc.Balances[def], balance)
+		if err != nil {
+			return err
+		}
+		acc.CurrentBalance = 0
+	}
+	if acc.TotalForDay > 0 {
+		total, err := scaleAmount(acc.TotalForDay, c.digits(def))
+		if err != nil {
+			return err
+		}
+		acc.TotalsForDay[def], err = addAmount(acc.TotalsForDay[def], total)
+		if err != nil {
+			return err
+		}
+		acc.TotalForDay = 0
+	}
+	return nil
+}
+
+// currencies returns the currency codes held by the account, sorted
+func (acc *account) currencies() []string {
+	codes := make([]string, 0, len(acc.Balances))
+	for code := range acc.Balances {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// getAccount reads an account from the ledger
+func getAccount(stub shim.ChaincodeStubInterface, name string) (*account, error) {
+	Accountbytes, err := stub.GetState(name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", name)
+	}
+	if Accountbytes == nil {
+		return nil, newError(codeAccountNotFound, details{"account": name})
+	}
+	return decodeAccount(stub, name, Accountbytes)
+}
+
+// isAccountRecord tells whether a value read from the ledger is an account
+func isAccountRecord(value []byte) bool {
+	var doc struct {
+		ObjectType string `json:"docType"`
+	}
+	return json.Unmarshal(value, &doc) == nil && doc.ObjectType == "ACCOUNT"
 }
 
+// decodeAccount unmarshals an account record read from the ledger
+func decodeAccount(stub shim.ChaincodeStubInterface, name string, Accountbytes []byte) (*account, error) {
+	acc := &account{}
+	err := json.Unmarshal(Accountbytes, acc)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", name)
+	}
+	if acc.ObjectType != "ACCOUNT" {
+		return nil, newError(codeNotAnAccount, details{"account": name})
+	}
+	c, err := getCurrencies(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = acc.normalize(c)
+	if err != nil {
+		return nil, err
+	}
+	return acc, nil
+}
+
+// putAccount writes an account back to the ledger
+func putAccount(stub shim.ChaincodeStubInterface, acc *account) error {
+	Accountbytes, err := json.Marshal(acc)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(acc.Name, Accountbytes)
+	if err != nil {
+		return fmt.Errorf("PutState %s failed", acc.Name)
+	}
+	return nil
+}
 
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
-	
-  	_, args := stub.GetFunctionAndParameters()
-    
+
+	_, args := stub.GetFunctionAndParameters()
+
 	var err error
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	if len(args) < 1 || len(args) > 3 {
+		return errorResponse(stub, newError(codeWrongArgumentCount, details{"function": "init", "expected": "1 to 3"}))
+	}
+
+	// the reserve is created in the default currency of the bank
+	code, digits := defaultCurrency, defaultDigits
+	if len(args) > 1 {
+		if !currencyCode.MatchString(args[1]) {
+			return errorResponse(stub, newError(codeInvalidCurrency, details{"currency": args[1]}))
+		}
+		code = args[1]
+	}
+	if len(args) > 2 {
+		digits, err = strconv.Atoi(args[2])
+		if err != nil || digits < 0 || digits > maxDigits {
+			return errorResponse(stub, newError(codeInvalidDigits, details{"digits": args[2], "max": strconv.Itoa(maxDigits)}))
+		}
+	}
+	c := newCurrencies(code, digits)
+
+	// the identity deploying the chaincode owns the bank and administers it
+	caller, err := getIdentity(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = putRoles(stub, caller.MSPID, caller.CN, []string{roleBankAdmin})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = putDeployer(stub, caller.MSPID)
+	if err != nil {
+		return errorResponse(stub, err)
 	}
 
 	// Creation of MPLBANK
-	i, _ := strconv.ParseUint(args[0],10,64)
-    bank := &account { "ACCOUNT", "MPLBANK", i , 0, 0,  "jyg" }
+	i, err := c.parse(args[0], c.Default)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if i == 0 {
+		return errorResponse(stub, newError(codeEmptyReserve, details{"amount": args[0]}))
+	}
+	// setdeltas MPLBANK true keeps the openings from conflicting, at the cost of its history, see deltas.go
+	bank := &account{ObjectType: "ACCOUNT", Name: "MPLBANK", Balances: map[string]uint64{c.Default: i}, TotalsForDay: map[string]uint64{}, Owner: caller.key(), Status: statusOpen}
 
-    bankJSONasBytes, err := json.Marshal(bank)
+	bankJSONasBytes, err := json.Marshal(bank)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(stub, err)
 	}
 
-    err = stub.PutState("MPLBANK", bankJSONasBytes)
+	err = stub.PutState("MPLBANK", bankJSONasBytes)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(stub, err)
 	}
-  
-    indexName := "owner~name"
+
+	indexName := "owner~name"
 	OwnerNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{bank.Owner, bank.Name})
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(stub, err)
 	}
-	
+
 	value := []byte{0x00}
-	stub.PutState(OwnerNameIndexKey, value)
+	err = stub.PutState(OwnerNameIndexKey, value)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	// the reserve is the first money in circulation
+	s := newSupply()
+	err = s.mint(c.Default, i)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = putSupply(stub, s)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
+	err = putCurrencies(stub, c)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = putCalendar(stub, newCalendar())
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
-    err = stub.PutState("MPLBANK_DAY", []byte("0"))
+	err = putLimits(stub, newLimitsConfig())
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(stub, err)
 	}
 
 	return shim.Success(nil)
 }
 
-
-
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 
 	function, args := stub.GetFunctionAndParameters()
-	fmt.Println(function,args)
-
-
-   var requester string
-
-    creator, err  := stub.GetCreator()
-    // la premiere partie correspond au mspip
-    // ne faudrait il pas prendre le tout ?
-    n := bytes.Index(creator,[]byte("---"))  
-    ca := creator[n:]
-    //fmt.Println(string(ca))
-    if err == nil {
-    	block, _ := pem.Decode(ca)
-    	if block == nil {
-	    	fmt.Println("failed to parse certificate PEM")
-	    	requester=""
-	    } else {
-    		cert, err := x509.ParseCertificate(block.Bytes)
-    		if err != nil {
-    			fmt.Println("failed to parse certificate: " + err.Error())
-    		}
-    		fmt.Println(cert.Subject.CommonName)
-    		requester = cert.Subject.CommonName
-    	}
-    }
-
-	if function == "move" {
-		// Make payment of X units from A to B
-		return t.invoke(stub, args, requester)
-	} else if function == "delete" {
-		// Deletes an entity from its state
-		return t.delete(stub, args)
-	} else if function == "query" {
-		// the old "Query" is now implemtned in invoke
-		return t.query(stub, args)
-	} else if function == "queryplafond" {
-		// the old "Query" is now implemtned in invoke
-		return t.queryplafond(stub, args)
-	} else if function == "gethistory" {
-		// the old "Query" is now implemtned in invoke
-		return t.getHistory(stub, args)
-	} else if function == "getaccountsbyowner" {
-		// the old "Query" is now implemtned in invoke
-		return t.getaccountsbyowner(stub, requester)
-	} else if function == "changeday" {
-		// the old "Query" is now implemtned in invoke
-		return t.changeday(stub)
-	} else if function == "getaccounts" {
-		// the old "Query" is now implemtned in invoke
-		return t.getaccounts(stub)
-	}
-
-
-	return shim.Error("Invalid invoke function name")
-}
 
+	f, ok := registry[function]
+	if !ok {
+		return errorResponse(stub, newError(codeUnknownFunction, details{"function": function}))
+	}
+
+	err := f.checkArgs(args)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	caller, err := getIdentity(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = f.checkAccess(caller)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
+	return f.handler(t, stub, args, caller)
+}
 
-// Transaction makes payment of X units from A to B
-func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface,args []string, requester string) pb.Response {
+// Transaction makes payment of X units from A to B, in the default currency unless one is given,
+// with an optional memo and reference kept in the transfer record, and an
+// optional idempotency key that makes a retry return the result of the first move
+func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
 
-	var X uint64          // Transaction value
+	var X uint64 // Transaction value, in minor units
 	var err error
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	var code string
+	if len(args) > 3 {
+		code = args[3]
+	}
+	currency, err := currencies.resolve(code)
+	if err != nil {
+		return errorResponse(stub, err)
 	}
+	digits := currencies.digits(currency)
 
-    var DebitAccount, CreditAccount account
-  	
 	// Perform the execution
-	X, err = strconv.ParseUint(args[2],10,64)
+	X, err = parseAmount(args[2], digits)
 	if err != nil {
-		return shim.Error("Invalid transaction amount, expecting a integer value")
+		return errorResponse(stub, err)
+	}
+	memo, reference, key := optional(args, 4), optional(args, 5), optional(args, 6)
+	err = checkMemo(memo)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = checkReference(reference)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = checkIdempotencyKey(key)
+	if err != nil {
+		return errorResponse(stub, err)
 	}
 
+	// both accounts are read before either is written, a single account would be credited with a stale copy
+	if args[0] == args[1] {
+		return errorResponse(stub, newError(codeSameAccount, details{"account": args[0]}))
+	}
 
 	// Get the state from the ledger
-	// TODO: will be nice to have a GetAllState call to ledger
-	DebitAccountbytes, err := stub.GetState(args[0])
+	DebitAccount, err := getAccount(stub, args[0])
 	if err != nil {
-		return shim.Error("Failed to get state for debut account")
+		return errorResponse(stub, err)
 	}
-	if DebitAccountbytes == nil {
-		return shim.Error("Entity not found")
+	if DebitAccount.isClosed() {
+		return errorResponse(stub, newError(codeAccountClosed, details{"account": DebitAccount.Name}))
+	}
+
+	if DebitAccount.Name != "MPLBANK" {
+		if !caller.owns(DebitAccount) {
+			return errorResponse(stub, newError(codeNotOwner, details{"account": DebitAccount.Name}))
+		}
+	} else if !caller.hasRole(roleTeller) && !caller.hasRole(roleBankAdmin) {
+		return errorResponse(stub, newError(codeAccessDenied, details{"function": "move", "account": DebitAccount.Name, "roles": roleTeller + ", " + roleBankAdmin}))
 	}
 
-    err = json.Unmarshal([]byte(DebitAccountbytes), &DebitAccount)
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	now, err := txTime(stub)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to decode JSON of: " + args[0] + "\"}"
-		return shim.Error(jsonResp)
+		return errorResponse(stub, err)
+	}
+	// a retry returns the result of the move that used the key, before it is rejected as a replay
+	var retry *idempotentMove
+	if key != "" {
+		retry = &idempotentMove{ObjectType: "IDEMPOTENT_MOVE", Key: key, TxID: stub.GetTxID(), BusinessDay: today,
+			Debit: DebitAccount.Name, Credit: args[1], Currency: currency, Amount: X, Memo: memo, Reference: reference}
+		result, err := checkRetry(stub, retry, today)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		if result != nil {
+			return shim.Success(result)
+		}
+	}
+	if reference != "" {
+		err = checkReplay(stub, DebitAccount.Name, today, reference)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
 	}
-	
 
-    if (DebitAccount.Name != "MPLBANK")  {
-    	if (DebitAccount.Owner != requester) {
-    		fmt.Println(DebitAccount.Owner)
-    		fmt.Println(requester)
-    		return shim.Error("Sorry but you are not the owner of this debit account. Transaction cancelled")
-    	}
-    } 
+	// the reserve keeps its debits as deltas, any other account folds them before it is debited
+	reserveDebit := DebitAccount.Deltas && DebitAccount.Name == "MPLBANK"
+	if DebitAccount.Deltas && !reserveDebit {
+		_, err = consolidate(stub, DebitAccount)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+	if !reserveDebit {
+		err = releaseExpiredHolds(stub, DebitAccount, today)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
 
+	if DebitAccount.LastDebitDay != today {
+		DebitAccount.TotalsForDay = map[string]uint64{}
+	}
 
+	limits, err := getLimits(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
-	MPLdaybytes, err := stub.GetState("MPLBANK_DAY")
+	// accounts are opened by openaccount, for the identity of their owner
+	CreditAccount, err := getAccount(stub, args[1])
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errorResponse(stub, err)
+	}
+	// the bank credits each currency wallet of an account once
+	if _, held := CreditAccount.Balances[currency]; held && (DebitAccount.Name == "MPLBANK") {
+		return errorResponse(stub, newError(codeAlreadyCredited, details{"account": CreditAccount.Name, "currency": currency}))
+	}
+	if CreditAccount.isClosed() {
+		return errorResponse(stub, newError(codeAccountClosed, details{"account": CreditAccount.Name}))
 	}
-	MPLday, _ := strconv.ParseUint(string(MPLdaybytes),10,64)
-	
 
-	if (DebitAccount.CurrentDay != MPLday) {
-		DebitAccount.TotalForDay = 0
+	if _, held := CreditAccount.Balances[currency]; !held && (DebitAccount.Name == "MPLBANK") {
+		err = checkWelcome(stub, CreditAccount.Name)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		openingLimit := limits.openingLimit(CreditAccount, currency, digits)
+		if X > openingLimit {
+			return errorResponse(stub, newError(codeOpeningLimitExceeded, details{"account": CreditAccount.Name, "amount": args[2], "limit": formatAmount(openingLimit, digits), "currency": currency}))
+		}
 	}
-	
-	CreditAccountbytes, err := stub.GetState(args[1])
+
+	totalForDay, err := addAmount(DebitAccount.TotalsForDay[currency], X)
 	if err != nil {
-		return shim.Error("Failed to get state for debut account")
+		return errorResponse(stub, err)
+	}
+	dailyLimit := limits.dailyLimit(DebitAccount, currency, digits)
+	if (totalForDay > dailyLimit) && (DebitAccount.Name != "MPLBANK") {
+		return errorResponse(stub, newError(codeDailyLimitExceeded, details{"account": DebitAccount.Name, "amount": args[2], "limit": formatAmount(dailyLimit, digits), "currency": currency}))
 	}
 
-	if CreditAccountbytes == nil {
-		if (DebitAccount.Name != "MPLBANK")  {
-			return shim.Error("Only the bank can open an account")
-		}
-		fmt.Printf("ouverture de compte %s\n", args[1])
-		if ( X > 10000 ) {
-		       return shim.Error("Montant demandé trop important")
-		};
+	debitBalance, err := subAmount(DebitAccount.Balances[currency], X)
+	if err != nil {
+		return errorResponse(stub, newError(codeInsufficientFunds, details{"account": DebitAccount.Name, "amount": args[2], "currency": currency}))
+	}
+	creditBalance, err := addAmount(CreditAccount.Balances[currency], X)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
-        
-	    CreditAccount = account { "ACCOUNT", args[1], 0, 0, MPLday, requester }
+	DebitAccount.TotalsForDay[currency] = totalForDay
+	DebitAccount.LastDebitDay = today
+	DebitAccount.Balances[currency] = debitBalance
+	CreditAccount.Balances[currency] = creditBalance
 
-	    indexName := "owner~name"
-		OwnerNameIndexKey, err := stub.CreateCompositeKey(indexName, []string{CreditAccount.Owner, CreditAccount.Name})
+	// above the approval threshold of the debit account the move waits for approvers, see approvals.go
+	if threshold := limits.approvalThreshold(DebitAccount, currency, digits); threshold > 0 && X > threshold && DebitAccount.Name != "MPLBANK" {
+		policy, err := getApprovalPolicy(stub)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(stub, err)
 		}
-	
-		value := []byte{0x00}
-		stub.PutState(OwnerNameIndexKey, value)
+		p := &pendingTransfer{ObjectType: "PENDING_TRANSFER", TxID: stub.GetTxID(), Debit: DebitAccount.Name, Credit: CreditAccount.Name,
+			Currency: currency, Amount: X, Memo: memo, Reference: reference, Requester: caller.key(), BusinessDay: today,
+			Expires: policy.expires(today), Status: pendingStatus, Approvals: []string{}}
+		err = holdForApproval(stub, DebitAccount, p)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		err = setEvent(stub, eventTransferPending, transferPendingEvent{
+			eventHeader:  newEventHeader(stub, eventTransferPending, today, caller),
+			Debit:        p.Debit,
+			Credit:       p.Credit,
+			Currency:     currency,
+			Amount:       newAmountValue(X, digits),
+			DebitBalance: newAmountValue(debitBalance, digits),
+			Held:         newAmountValue(DebitAccount.Held[currency], digits),
+			Memo:         memo,
+			Reference:    reference,
+			Expires:      p.Expires,
+			Quorum:       policy.Quorum,
+		})
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		return moved(stub, retry, []byte(pendingStatus))
+	}
 
+	// Write the state back to the ledger
+	if reserveDebit {
+		err = putDelta(stub, DebitAccount.Name, nil, map[string]uint64{currency: X})
+	} else {
+		err = putAccount(stub, DebitAccount)
+	}
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
+	if CreditAccount.Deltas {
+		err = putDelta(stub, CreditAccount.Name, map[string]uint64{currency: X}, nil)
 	} else {
-		if (DebitAccount.Name == "MPLBANK") {
-			return shim.Error("Your account has already been credited by the bank")
-		}
+		err = putAccount(stub, CreditAccount)
+	}
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
-    	err = json.Unmarshal([]byte(CreditAccountbytes), &CreditAccount)
-		if err != nil {
-			jsonResp := "{\"Error\":\"Failed to decode JSON of: " + args[1] + "\"}"
-			return shim.Error(jsonResp)
-		}
+	tr := newTransfer(stub, DebitAccount.Name, CreditAccount.Name, currency, X, today, now, caller)
+	tr.Memo, tr.Reference = memo, reference
+	err = putTransfer(stub, tr, now)
+	if err != nil {
+		return errorResponse(stub, err)
 	}
-		
 
-	if (DebitAccount.TotalForDay + X > 1000) && (DebitAccount.Name != "MPLBANK" ) {
-	       return shim.Error("Total amount for fund transfer is superior to 1000")
-	};
+	err = setEvent(stub, eventTransfer, transferEvent{
+		eventHeader:   newEventHeader(stub, eventTransfer, today, caller),
+		Debit:         DebitAccount.Name,
+		Credit:        CreditAccount.Name,
+		Currency:      currency,
+		Amount:        newAmountValue(X, digits),
+		DebitBalance:  newAmountValue(debitBalance, digits),
+		CreditBalance: newAmountValue(creditBalance, digits),
+		Memo:          memo,
+		Reference:     reference,
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
-	if X > DebitAccount.CurrentBalance   {
-	      return shim.Error("Insufficient funds in debit account")
+	return moved(stub, retry, []byte("OK"))
+}
+
+// moved returns the result of a move, kept for the retries of its idempotency key if it has one
+func moved(stub shim.ChaincodeStubInterface, retry *idempotentMove, result []byte) pb.Response {
+	if retry != nil {
+		retry.Result = result
+		err := putIdempotentMove(stub, retry)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
 	}
+	return shim.Success(result)
+}
 
-	DebitAccount.TotalForDay = DebitAccount.TotalForDay + X
-	DebitAccount.CurrentBalance = DebitAccount.CurrentBalance - X
-	CreditAccount.CurrentBalance = CreditAccount.CurrentBalance + X
+// closeaccount closes an account: args are the account and, when its balance
+// is not zero, the account that receives the remaining balance
+func (t *SimpleChaincode) closeaccount(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
 
-	
-	fmt.Printf("DebitNewBalance = %d, CreditNewBalance = %d, TotalTransferForTheDay = %d\n",DebitAccount.CurrentBalance , CreditAccount.CurrentBalance, DebitAccount.TotalForDay)
-	
+	if args[0] == "MPLBANK" {
+		return errorResponse(stub, newError(codeBankAccount, details{"account": args[0], "function": "closeaccount"}))
+	}
 
-    DebitAccountbytes, err = json.Marshal(DebitAccount)
+	acc, err := getAccount(stub, args[0])
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(stub, err)
+	}
+	if acc.isClosed() {
+		return errorResponse(stub, newError(codeAccountClosed, details{"account": acc.Name}))
 	}
-    CreditAccountbytes, err = json.Marshal(CreditAccount)
+	if !caller.owns(acc) && !caller.hasRole(roleBankAdmin) {
+		return errorResponse(stub, newError(codeNotOwner, details{"account": acc.Name}))
+	}
+	_, err = consolidate(stub, acc)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(stub, err)
 	}
-
-
-	// Write the state back to the ledger
-	err = stub.PutState(DebitAccount.Name, DebitAccountbytes)
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = releaseExpiredHolds(stub, acc, today)
 	if err != nil {
-		return shim.Error("PutState Debit Account failed")
+		return errorResponse(stub, err)
+	}
+	for _, amount := range acc.Held {
+		if amount > 0 {
+			return errorResponse(stub, newError(codeFundsHeld, details{"account": acc.Name}))
+		}
 	}
 
-    err = stub.PutState(CreditAccount.Name, CreditAccountbytes)
+	currencies, err := getCurrencies(stub)
 	if err != nil {
-		return shim.Error("PutState Credit Account failed")
+		return errorResponse(stub, err)
+	}
+	event := accountClosedEvent{
+		eventHeader: newEventHeader(stub, eventAccountClosed, today, caller),
+		Account:     acc.Name,
+		Owner:       acc.Owner,
+		Swept:       map[string]amountValue{},
 	}
-	
-	
-	return shim.Success([]byte("OK"))
-}
 
-// Deletes an entity from state
-func (t *SimpleChaincode) delete(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	empty := true
+	for _, amount := range acc.Balances {
+		if amount > 0 {
+			empty = false
+		}
 	}
 
-	A := args[0]
+	if !empty {
+		if len(args) < 2 || args[1] == "" {
+			return errorResponse(stub, newError(codeSweepRequired, details{"account": acc.Name}))
+		}
+		if args[1] == acc.Name {
+			return errorResponse(stub, newError(codeSameAccount, details{"account": acc.Name}))
+		}
+		sweep, err := getAccount(stub, args[1])
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		if sweep.isClosed() {
+			return errorResponse(stub, newError(codeAccountClosed, details{"account": sweep.Name}))
+		}
+
+		for currency, amount := range acc.Balances {
+			sweep.Balances[currency], err = addAmount(sweep.Balances[currency], amount)
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+			if amount > 0 {
+				event.Swept[currency] = newAmountValue(amount, currencies.digits(currency))
+			}
+		}
+		event.SweepTo = sweep.Name
+		event.SweepBalance = map[string]amountValue{}
+		for currency, amount := range sweep.Balances {
+			event.SweepBalance[currency] = newAmountValue(amount, currencies.digits(currency))
+		}
+		swept := acc.Balances
+		acc.Balances = map[string]uint64{}
 
-	// Delete the key from the state in ledger
-	err := stub.DelState(A)
-	if err != nil {
-		return shim.Error("Failed to delete state")
+		if sweep.Deltas {
+			err = putDelta(stub, sweep.Name, swept, nil)
+		} else {
+			err = putAccount(stub, sweep)
+		}
+		if err != nil {
+			return errorResponse(stub, err)
+		}
 	}
 
-	return shim.Success(nil)
-}
+	acc.Status = statusClosed
+	err = putAccount(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
-// Deletes an entity from state
-func (t *SimpleChaincode) changeday(stub shim.ChaincodeStubInterface) pb.Response {
-	var  err error
-	var MPLday int
-	
-	//fmt.Println("coucou")
-	
-	MPLdaybytes, err := stub.GetState("MPLBANK_DAY")
+	// a closed account no longer shows up in the owner index
+	OwnerNameIndexKey, err := stub.CreateCompositeKey("owner~name", []string{acc.Owner, acc.Name})
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errorResponse(stub, err)
 	}
-	MPLday, _ = strconv.Atoi(string(MPLdaybytes))
-	MPLday++
-	
-	err = stub.PutState("MPLBANK_DAY", []byte(strconv.Itoa(MPLday)))
+	err = stub.DelState(OwnerNameIndexKey)
 	if err != nil {
-		return shim.Error(err.Error());
-	}	
-
-	return shim.Success([]byte(string(MPLday)))
-}
+		return errorResponse(stub, fmt.Errorf("Failed to delete index entry of %s", acc.Name))
+	}
 
+	err = setEvent(stub, eventAccountClosed, event)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
+	return shim.Success(nil)
+}
 
-func (t *SimpleChaincode) getaccounts(stub shim.ChaincodeStubInterface) pb.Response {
+// getaccounts lists the accounts, args are an optional format, page size,
+// bookmark and filter, see accountFilter
+func (t *SimpleChaincode) getaccounts(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
 
-	resultsIterator, err := stub.GetStateByRange("\"", "}")
+	format, err := parseFormat(optional(args, 0))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(stub, err)
+	}
+	pageSize, err := parsePageSize(optional(args, 1))
+	if err != nil {
+		return errorResponse(stub, err)
 	}
-	defer resultsIterator.Close()
 
-	match, _ := regexp.Compile("MPLBANK|owner~name")
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	filter, err := parseAccountFilter(optional(args, 3), currencies)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
-	// buffer is a JSON array containing QueryResults
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
+	p, err := rangePage(stub, "", "", pageSize, optional(args, 2))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
-	bArrayMemberAlreadyWritten := false
-	for resultsIterator.HasNext() {
-	//	queryResultKey, queryResultValue, err := resultsIterator.Next()
-		queryResultKey,  err := resultsIterator.Next()
+	resp := &accountListResponse{Version: responseVersion, Accounts: []*accountResponse{}, Fetched: len(p.Records), Bookmark: p.Bookmark}
+	for _, queryResultKey := range p.Records {
+		// the configuration records and the index entries are not accounts
+		if !isAccountRecord(queryResultKey.Value) {
+			continue
+		}
+		acc, err := decodeAccount(stub, queryResultKey.Key, queryResultKey.Value)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(stub, err)
 		}
-
-		if (!match.MatchString(queryResultKey.Key)) {
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
+		err = settle(stub, acc)
+		if err != nil {
+			return errorResponse(stub, err)
 		}
-	//	buffer.WriteString("{\"Key\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(queryResultKey.Key)
-		buffer.WriteString("\"")
-
-	//	buffer.WriteString(", \"Record\":")
-		// Record is a JSON object, so we write as-is
-	//	buffer.WriteString(string(queryResultKey.Value))
-	//	buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+		if filter.match(acc) {
+			resp.Accounts = append(resp.Accounts, newAccountResponse(acc, currencies, format))
 		}
 	}
-	buffer.WriteString("]")
-
-	fmt.Printf("-  queryResult:\n%s\n", buffer.String())
 
-	return shim.Success(buffer.Bytes())
+	return respond(stub, resp)
 }
 
+// newAccountSummary describes an account in the verbose format of the account lists
+func newAccountSummary(acc *account) accountSummary {
+	status := statusOpen
+	if acc.isClosed() {
+		status = statusClosed
+	}
+	return accountSummary{Name: acc.Name, Owner: acc.Owner, Tier: acc.Tier, Status: status}
+}
 
-// Query callback representing the query of a chaincode
-func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface,args []string) pb.Response {
-
-	var acc account // Entities
-	var err error
+// Query callback representing the query of a chaincode, args are the account and an optional format
+func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the person to query")
+	format, err := parseFormat(optional(args, 1))
+	if err != nil {
+		return errorResponse(stub, err)
 	}
 
-
 	// Get the state from the ledger
-	// TODO: will be nice to have a GetAllState call to ledger
-	Accountbytes, err := stub.GetState(args[0])
+	acc, err := getAccount(stub, args[0])
 	if err != nil {
-		return shim.Error("Failed to get state for debut account")
+		return errorResponse(stub, err)
 	}
-	if Accountbytes == nil {
-		return shim.Error("Entity not found")
+	// the balance of an account in delta mode is its base plus its deltas
+	err = settle(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
 	}
 
-    err = json.Unmarshal([]byte(Accountbytes), &acc)
+	currencies, err := getCurrencies(stub)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to decode JSON of: " + args[0] + "\"}"
-		return shim.Error(jsonResp)
+		return errorResponse(stub, err)
 	}
 
-    i := strconv.FormatUint(acc.CurrentBalance,10)
-	jsonResp := "{\"Name\":\"" + acc.Name + "\",\"Amount\":\"" + i + "\"}"
-	//fmt.Printf("Query Response:%s\n", jsonResp)
-	return shim.Success([]byte(jsonResp))
+	return respond(stub, newAccountResponse(acc, currencies, format))
 }
 
+// queryplafond returns the daily limit of an account and what it already
+// transferred today, args are the account, an optional currency and format
+func (t *SimpleChaincode) queryplafond(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
 
-// Query callback representing the query of a chaincode
-func (t *SimpleChaincode) queryplafond(stub shim.ChaincodeStubInterface,args []string) pb.Response {
-	var acc account // Entities
-	var err error
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the person to query")
+	format, err := parseFormat(optional(args, 2))
+	if err != nil {
+		return errorResponse(stub, err)
 	}
 
-
 	// Get the state from the ledger
-	// TODO: will be nice to have a GetAllState call to ledger
-	Accountbytes, err := stub.GetState(args[0])
+	acc, err := getAccount(stub, args[0])
 	if err != nil {
-		return shim.Error("Failed to get state for debut account")
+		return errorResponse(stub, err)
 	}
-	if Accountbytes == nil {
-		return shim.Error("Entity not found")
+
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currency, err := currencies.resolve(optional(args, 1))
+	if err != nil {
+		return errorResponse(stub, err)
 	}
 
-    err = json.Unmarshal([]byte(Accountbytes), &acc)
+	today, err := currentBusinessDay(stub)
 	if err != nil {
-		jsonResp := "{\"Error\":\"Failed to decode JSON of: " + args[0] + "\"}"
-		return shim.Error(jsonResp)
+		return errorResponse(stub, err)
 	}
 
+	if acc.LastDebitDay != today {
+		acc.TotalsForDay = map[string]uint64{}
+	}
 
-	MPLdaybytes, err := stub.GetState("MPLBANK_DAY")
+	limits, err := getLimits(stub)
 	if err != nil {
-		return shim.Error("Failed to get state")
+		return errorResponse(stub, err)
 	}
-	MPLday, _ := strconv.ParseUint(string(MPLdaybytes),10,64)
-	
 
-	if (acc.CurrentDay != MPLday) {
-		acc.TotalForDay = 0
+	total := currencies.value(acc.TotalsForDay[currency], currency)
+	limit := limits.dailyLimit(acc, currency, currencies.digits(currency))
+	resp := &limitResponse{
+		Version:  responseVersion,
+		Name:     acc.Name,
+		Currency: currency,
+		Total:    total.Amount,
+		Limit:    currencies.format(limit, currency),
+	}
+	if format == formatVerbose {
+		remaining := uint64(0)
+		if limit > acc.TotalsForDay[currency] {
+			remaining = limit - acc.TotalsForDay[currency]
+		}
+		resp.Remaining = currencies.format(remaining, currency)
+		resp.TotalMinor = total.Minor
+		resp.LimitMinor = currencies.value(limit, currency).Minor
+		resp.BusinessDay = today
 	}
-	
-	i := strconv.FormatUint(acc.TotalForDay,10)
-	jsonResp := "{\"Name\":\"" + args[0] + "\",\"Total FT\":\"" + i + "\"}"
-	//fmt.Printf("Query Response:%s\n", jsonResp)
-	return shim.Success([]byte(jsonResp))
+	return respond(stub, resp)
 }
 
+// getHistory returns the state of an account after each of its transactions,
+// args are the account and an optional format, page size, bookmark, from and
+// to timestamps (RFC 3339, both included) and order (asc or desc). The shim
+// has no paginated history, the bookmark is the TxId of the next entry. Only
+// the owner, the auditors and the bank admins read it, only the last two the
+// history of an account deleted.
+func (t *SimpleChaincode) getHistory(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	account_target := args[0]
 
-func (t *SimpleChaincode) getHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	format, err := parseFormat(optional(args, 1))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	pageSize, err := parsePageSize(optional(args, 2))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	bookmark := optional(args, 3)
+	from, err := parseTime(optional(args, 4))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	to, err := parseTime(optional(args, 5))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	order, err := parseOrder(optional(args, 6))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
-	if len(args) < 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
 	}
 
-	account_target := args[0]
+	acc, err := getAccount(stub, account_target)
+	if isError(err, codeAccountNotFound) {
+		acc, err = &account{Name: account_target}, nil
+	}
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if !caller.reads(acc) {
+		return errorResponse(stub, newError(codeNotOwner, details{"account": account_target}))
+	}
 
-	fmt.Printf("- start getHistory For Account: %s\n", account_target)
+	err = checkNoDeltas(stub, account_target, "gethistory")
+	if err != nil {
+		return errorResponse(stub, err)
+	}
 
 	resultsIterator, err := stub.GetHistoryForKey(account_target)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(stub, err)
 	}
 	defer resultsIterator.Close()
 
-	// buffer is a JSON array containing historic values for the marble
-	var buffer bytes.Buffer
-	var acc account
-
-	buffer.WriteString("{ \"history\" : [")
-
-	bArrayMemberAlreadyWritten := false
+	// the time filter applies before the order and the pages
+	versions := []*queryresult.KeyModification{}
 	for resultsIterator.HasNext() {
 		historicValue, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(stub, err)
 		}
-		// Add a comma before array members, suppress it for the first array member
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
+		ts := versionTime(historicValue)
+		if !from.IsZero() && ts.Before(from) || !to.IsZero() && ts.After(to) {
+			continue
 		}
-		buffer.WriteString("{\"TxId\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(historicValue.TxId)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(", \"CurrentBalance\":")
-		// historicValue is a JSON marble, so we write as-is
+		versions = append(versions, historicValue)
+	}
+	if order == orderDesc {
+		for i, j := 0, len(versions)-1; i < j; i, j = i+1, j-1 {
+			versions[i], versions[j] = versions[j], versions[i]
+		}
+	}
 
-		err = json.Unmarshal(historicValue.Value, &acc)
-		if err != nil {
-				return shim.Error("error to decode JSON")
+	resp := &historyResponse{Version: responseVersion, Name: account_target, History: []historyEntry{}}
+	for _, historicValue := range versions {
+		if bookmark != "" {
+			if historicValue.TxId != bookmark {
+				continue
+			}
+			bookmark = ""
+		}
+		if pageSize > 0 && int32(len(resp.History)) == pageSize {
+			resp.Bookmark = historicValue.TxId
+			break
 		}
 
-		buffer.WriteString("\"")
-		buffer.WriteString(strconv.FormatUint(acc.CurrentBalance,10))
-		buffer.WriteString("\"")
-		
-	//	buffer.WriteString(string(acc.CurrentBalance))
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+		entry := historyEntry{TxID: historicValue.TxId, Deleted: historicValue.IsDelete}
+		if ts := versionTime(historicValue); !ts.IsZero() {
+			entry.Timestamp = ts.Format(time.RFC3339Nano)
+		}
+		// a deleted version has no value
+		if !historicValue.IsDelete {
+			var acc account
+			err = json.Unmarshal(historicValue.Value, &acc)
+			if err != nil {
+				return errorResponse(stub, fmt.Errorf("Failed to decode JSON of: %s", account_target))
+			}
+			err = acc.normalize(currencies)
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+
+			view := newAccountResponse(&acc, currencies, format)
+			entry.Balance = view.Balance
+			entry.Balances = view.Balances
+			entry.Minor = view.Minor
+			entry.Status = view.Status
+			entry.Account = newAccountResponse(&acc, currencies, formatVerbose)
+		}
+		tr, err := getTransfer(stub, historicValue.TxId)
+		if err != nil && !isError(err, codeTransferNotFound) {
+			return errorResponse(stub, err)
+		}
+		// the caller reads the account, so it reads the transfers debiting or crediting it, as gettransfer
+		if tr != nil && (tr.Debit == account_target || tr.Credit == account_target) {
+			entry.Memo, entry.Reference = tr.Memo, tr.Reference
+		}
+		resp.History = append(resp.History, entry)
 	}
-	buffer.WriteString("] }")
+	resp.Fetched = len(resp.History)
 
-	//fmt.Printf("- getHistory returning:\n%s\n", buffer.String())
+	return respond(stub, resp)
+}
 
-	return shim.Success(buffer.Bytes())
+// versionTime returns the timestamp of a version, zero when the peer gave none
+func versionTime(mod *queryresult.KeyModification) time.Time {
+	if mod.Timestamp == nil {
+		return time.Time{}
+	}
+	return time.Unix(mod.Timestamp.Seconds, int64(mod.Timestamp.Nanos)).UTC()
 }
 
-func (t *SimpleChaincode) getaccountsbyowner(stub shim.ChaincodeStubInterface, owner string) pb.Response {
+// getaccountsbyowner lists the accounts of the caller, args are an optional
+// format, page size and bookmark
+func (t *SimpleChaincode) getaccountsbyowner(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
 
-	ResultsIterator, err := stub.GetStateByPartialCompositeKey("owner~name", []string{owner})
+	format, err := parseFormat(optional(args, 0))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	pageSize, err := parsePageSize(optional(args, 1))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(stub, err)
 	}
-	defer ResultsIterator.Close()
+	bookmark := optional(args, 2)
 
-	// Iterate through result set and for 
-	var i int
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	bArrayMemberAlreadyWritten := false
-	for i = 0; ResultsIterator.HasNext(); i++ {
-		// Note that we don't get the value (2nd return variable), we'll just get the marble name from the composite key
-		NameKey,  err := ResultsIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
+	// accounts opened before identities carried the MSP ID are indexed by
+	// common name, they belong to the MSP that deployed the chaincode
+	owners := []string{caller.key()}
+	if caller.legacy {
+		owners = append(owners, caller.CN)
+	}
 
-		// get the color and name from color~name composite key
-		_, compositeKeyParts, err := stub.SplitCompositeKey(NameKey.Key)
-		if err != nil {
-			return shim.Error(err.Error())
+	// the bookmark is an index key, it tells which owner the page starts in.
+	// It becomes the start key of the range, so it has to be one of the caller's.
+	if bookmark != "" {
+		start := -1
+		for n, owner := range owners {
+			prefix, err := stub.CreateCompositeKey("owner~name", []string{owner})
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+			if strings.HasPrefix(bookmark, prefix) {
+				start = n
+				break
+			}
 		}
-
-		returnedAccountName := compositeKeyParts[1]
-	//	fmt.Printf("- found an account  from index:%s name:%s\n", objectType, returnedAccountName)
-
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
+		if start < 0 {
+			return errorResponse(stub, newError(codeInvalidArgument, details{"function": "getaccountsbyowner", "argument": "bookmark", "type": argString}))
 		}
-		buffer.WriteString("\"")
-		buffer.WriteString(returnedAccountName)
-		buffer.WriteString("\"")
-
-		bArrayMemberAlreadyWritten = true
-		
+		owners = owners[start:]
 	}
 
-	buffer.WriteString("]")
+	resp := &accountsResponse{Version: responseVersion, Accounts: []accountSummary{}}
+	for n, owner := range owners {
+		remaining := pageSize
+		if pageSize > 0 {
+			remaining = pageSize - int32(resp.Fetched)
+		}
+		if pageSize > 0 && remaining == 0 {
+			// the page is full, the next one starts with the accounts of this owner
+			resp.Bookmark, err = stub.CreateCompositeKey("owner~name", []string{owner})
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+			break
+		}
 
-	fmt.Printf("queryResult:\n%s\n", buffer.String())
+		p, err := compositePage(stub, "owner~name", []string{owner}, remaining, bookmark)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		bookmark = ""
+		resp.Fetched += len(p.Records)
+
+		for _, NameKey := range p.Records {
+			// get the owner and name from owner~name composite key
+			_, compositeKeyParts, err := stub.SplitCompositeKey(NameKey.Key)
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+
+			returnedAccountName := compositeKeyParts[1]
+
+			summary := accountSummary{Name: returnedAccountName}
+			if format == formatVerbose {
+				acc, err := getAccount(stub, returnedAccountName)
+				if err != nil {
+					return errorResponse(stub, err)
+				}
+				summary = newAccountSummary(acc)
+			}
+			resp.Accounts = append(resp.Accounts, summary)
+		}
 
-	return shim.Success(buffer.Bytes())
+		if p.Bookmark != "" || n == len(owners)-1 {
+			resp.Bookmark = p.Bookmark
+			break
+		}
+	}
 
+	return respond(stub, resp)
 }
 
-
-
-
 func main() {
 	err := shim.Start(new(SimpleChaincode))
 	if err != nil {
@@ -16,12 +16,49 @@ limitations under the License.
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
 )
 
+// setCreator makes the following transactions signed by mspid with a certificate for cn
+func setCreator(t *testing.T, stub *shim.MockStub, mspid string, cn string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		fmt.Println("Failed to generate key", err)
+		t.FailNow()
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{mspid}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		fmt.Println("Failed to create certificate", err)
+		t.FailNow()
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspid, IdBytes: certPEM})
+	if err != nil {
+		fmt.Println("Failed to serialize identity", err)
+		t.FailNow()
+	}
+	stub.Creator = creator
+}
+
 func checkInit(t *testing.T, stub *shim.MockStub, args [][]byte) {
 	res := stub.MockInit("1", args)
 	if res.Status != shim.OK {
@@ -52,7 +89,7 @@ func checkQuery(t *testing.T, stub *shim.MockStub, name string) {
 		fmt.Println("Query", name, "failed to get value")
 		t.FailNow()
 	}
-	fmt.Println(string(res.Payload))  
+	fmt.Println(string(res.Payload))
 }
 
 func checkQuery2(t *testing.T, stub *shim.MockStub, fonc string, value string) {
@@ -71,86 +108,196 @@ func checkQuery2(t *testing.T, stub *shim.MockStub, fonc string, value string) {
 func checkInvoke(t *testing.T, stub *shim.MockStub, args [][]byte) {
 	res := stub.MockInvoke("1", args)
 	if res.Status != shim.OK {
-		fmt.Println(string(res.Message))
-		//t.FailNow()
+		fmt.Println("Invoke", string(args[0]), "failed", string(res.Message))
+		t.FailNow()
+	}
+}
+
+func checkInvokeFails(t *testing.T, stub *shim.MockStub, args [][]byte) {
+	res := stub.MockInvoke("1", args)
+	if res.Status == shim.OK {
+		fmt.Println("Invoke", string(args[0]), "succeeded but was expected to fail")
+		t.FailNow()
 	}
+	fmt.Println(string(res.Message))
 }
 
 func TestExample02_Init(t *testing.T) {
 	scc := new(SimpleChaincode)
 	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
 
 	// Init A=123 B=234
 	checkInit(t, stub, [][]byte{[]byte("init"), []byte("9000000000")})
 
-	checkState(t, stub, "MPLBANK", "9000000000")
+	checkState(t, stub, "MPLBANK", `{"docType":"ACCOUNT","name":"MPLBANK","balances":{"EUR":900000000000},"totalsforday":{},"owner":"Org1MSP/jyg","status":"OPEN"}`)
+	checkState(t, stub, "MPLBANK_CALENDAR", `{"docType":"CALENDAR","timezone":"UTC","cutoffhour":0,"offset":0}`)
 }
 
-
-
 func TestExample02_Invoke(t *testing.T) {
 	scc := new(SimpleChaincode)
 	stub := shim.NewMockStub("ex02", scc)
 
+	setCreator(t, stub, "Org1MSP", "jyg")
+
 	// Init A=567 B=678
 	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE", "COMPTE_FABIEN", "COMPTE_ESTELLE", "COMPTE_JYG2")
 
 	// Invoke A->B for 123
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("1000")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("MPLBANK"), []byte("COMPTE_FABIEN"), []byte("100000")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("MPLBANK"), []byte("COMPTE_ESTELLE"), []byte("200")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("MPLBANK"), []byte("COMPTE_JYG2"), []byte("10000")})
-	
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("2")})
-
-	
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1100")})
-    checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_ESTELLE"), []byte("COMPTE_KARINE"), []byte("300")})
-
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("1000")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_FABIEN"), []byte("100000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_ESTELLE"), []byte("200")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG2"), []byte("10000")})
+
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("2")})
+
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1100")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_ESTELLE"), []byte("COMPTE_KARINE"), []byte("300")})
+
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
 	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
-	
-	
-	
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
+
 	checkQuery(t, stub, "COMPTE_JYG")
 	checkQuery(t, stub, "COMPTE_JYG2")
 	checkQuery2(t, stub, "queryplafond", "COMPTE_JYG")
-	
+
 	checkQuery(t, stub, "COMPTE_KARINE")
 
 	checkInvoke(t, stub, [][]byte{[]byte("getaccounts")})
 
-
-  
 	// Invoke B->A for 234
-	//checkInvoke(t, stub, [][]bytge{[]byte("invoke"), []byte("B"), []byte("A"), []byte("234")})
+	//checkInvoke(t, stub, [][]bytge{[]byte("move"), []byte("B"), []byte("A"), []byte("234")})
 	//checkQuery(t, stub, "A", "678")
 	//checkQuery(t, stub, "B", "567")
 	//checkQuery(t, stub, "A", "678")
 	//checkQuery(t, stub, "B", "567")
 }
 
-
 func TestExample02_Query(t *testing.T) {
 	scc := new(SimpleChaincode)
 	stub := shim.NewMockStub("ex02", scc)
 
+	setCreator(t, stub, "Org1MSP", "jyg")
+
 	// Init A=345 B=456
-	checkInit(t, stub, [][]byte{[]byte("init"),  []byte("900000000")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("MPLBANK"), []byte("COMPTE_JYG2"), []byte("10000")})
-	
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000000")})
+	openAccounts(t, stub, "COMPTE_JYG2", "COMPTE_KARINE")
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG2"), []byte("10000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("1000")})
+
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
 
 	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
-	checkInvoke(t, stub, [][]byte{[]byte("invoke"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG2"), []byte("COMPTE_KARINE"), []byte("400")})
 	checkQuery(t, stub, "COMPTE_JYG2")
 	checkQuery(t, stub, "COMPTE_KARINE")
 
-}
\ No newline at end of file
+}
+
+func TestExample02_CloseAccount(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte("karine"), []byte("customer")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("100")})
+
+	// the bank reserve and system keys cannot be closed
+	checkInvokeFails(t, stub, [][]byte{[]byte("closeaccount"), []byte("MPLBANK")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("closeaccount"), []byte("MPLBANK_CALENDAR")})
+
+	// somebody else's account
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkInvokeFails(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE")})
+
+	// a balance left on the account needs a sweep account
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkInvokeFails(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_JYG")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_JYG"), []byte("COMPTE_JYG")})
+	checkInvoke(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE")})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("query"), []byte("COMPTE_KARINE")})
+	if string(res.Payload) != `{"version":1,"name":"COMPTE_KARINE","currency":"EUR","balance":"2100.00","balances":{"EUR":"2100.00"}}` {
+		fmt.Println("balance was not swept", string(res.Payload))
+		t.FailNow()
+	}
+
+	// the account is kept, closed, and out of the owner index
+	acc, err := getAccount(stub, "COMPTE_JYG")
+	if err != nil || !acc.isClosed() || acc.CurrentBalance != 0 {
+		fmt.Println("account was not closed", acc, err)
+		t.FailNow()
+	}
+	res = stub.MockInvoke("1", [][]byte{[]byte("getaccountsbyowner")})
+	if string(res.Payload) != `{"version":1,"accounts":[{"name":"COMPTE_KARINE"},{"name":"MPLBANK"}],"fetched":2}` {
+		fmt.Println("closed account still indexed", string(res.Payload))
+		t.FailNow()
+	}
+
+	checkInvokeFails(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_JYG")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_KARINE"), []byte("COMPTE_JYG"), []byte("1")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("1")})
+}
+
+func TestExample02_Currencies(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000000"), []byte("EUR")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkInvokeFails(t, stub, [][]byte{[]byte("issue"), []byte("5000"), []byte("usd")})
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("50000"), []byte("USD")})
+
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("3000"), []byte("USD")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("3000"), []byte("USD")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("3000"), []byte("GBP")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("100")})
+
+	// daily limits are counted per currency
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("default"), []byte(""), []byte("daily"), []byte("2000"), []byte("USD")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1000")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("1500"), []byte("USD")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("600"), []byte("USD")})
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("query"), []byte("COMPTE_KARINE")})
+	if string(res.Payload) != `{"version":1,"name":"COMPTE_KARINE","currency":"EUR","balance":"1100.00","balances":{"EUR":"1100.00","USD":"1500.00"}}` {
+		fmt.Println("unexpected balances", string(res.Payload))
+		t.FailNow()
+	}
+	res = stub.MockInvoke("1", [][]byte{[]byte("queryplafond"), []byte("COMPTE_JYG"), []byte("USD")})
+	if string(res.Payload) != `{"version":1,"name":"COMPTE_JYG","currency":"USD","total":"1500.00","limit":"2000.00"}` {
+		fmt.Println("unexpected limit", string(res.Payload))
+		t.FailNow()
+	}
+
+	// a record with a single balance is read in the default currency
+	stub.MockTransactionStart("legacy")
+	stub.PutState("COMPTE_OLD", []byte(`{"docType":"ACCOUNT","name":"COMPTE_OLD","currentbalance":700,"totalforday":0,"currentday":3,"owner":"jyg"}`))
+	stub.MockTransactionEnd("legacy")
+	res = stub.MockInvoke("1", [][]byte{[]byte("query"), []byte("COMPTE_OLD")})
+	if string(res.Payload) != `{"version":1,"name":"COMPTE_OLD","currency":"EUR","balance":"700.00","balances":{"EUR":"700.00"}}` {
+		fmt.Println("legacy balance not read", string(res.Payload))
+		t.FailNow()
+	}
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_OLD"), []byte("COMPTE_KARINE"), []byte("200")})
+
+	// its bare owner is a common name of the MSP that deployed the chaincode
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org2MSP"), []byte("jyg"), []byte("customer")})
+	setCreator(t, stub, "Org2MSP", "jyg")
+	checkError(t, stub, codeNotOwner, [][]byte{[]byte("move"), []byte("COMPTE_OLD"), []byte("COMPTE_KARINE"), []byte("200")})
+}
@@ -0,0 +1,189 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Accounts are opened by openaccount, for the identity it is given. A move
+// from MPLBANK used to open an unknown account for the caller, who is the
+// bank operator rather than the customer, it now fails with ACCOUNT_NOT_FOUND.
+
+// product types an account can be opened with
+const (
+	productCurrent  = "current"
+	productSavings  = "savings"
+	productMerchant = "merchant" // receives many payments, opened in delta mode
+)
+
+var knownProducts = []string{productCurrent, productSavings, productMerchant}
+
+// account names opened by openaccount, the world state keys of the bank
+// records start with the reserve name and are refused
+const (
+	minAccountNameLength = 3
+	maxAccountNameLength = 64
+	reservedPrefix       = "MPLBANK"
+)
+
+var accountName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// checkAccountName validates the name of a new account
+func checkAccountName(name string) error {
+	if len(name) < minAccountNameLength || len(name) > maxAccountNameLength || !accountName.MatchString(name) {
+		return newError(codeInvalidAccountName, details{"account": name, "min": strconv.Itoa(minAccountNameLength), "max": strconv.Itoa(maxAccountNameLength)})
+	}
+	if strings.HasPrefix(name, reservedPrefix) {
+		return newError(codeReservedAccountName, details{"account": name})
+	}
+	return nil
+}
+
+func isKnownProduct(product string) bool {
+	for _, p := range knownProducts {
+		if p == product {
+			return true
+		}
+	}
+	return false
+}
+
+// openaccount opens an account for a customer, args are the account, the MSP
+// ID and common name of its owner, its product type and an optional initial
+// funding from the reserve and its currency
+func (t *SimpleChaincode) openaccount(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	name, mspid, cn, product := args[0], args[1], args[2], args[3]
+	err := checkAccountName(name)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if mspid == "" || cn == "" || strings.Contains(mspid, "/") {
+		return errorResponse(stub, newError(codeInvalidOwner, details{"mspid": mspid, "cn": cn}))
+	}
+	if !isKnownProduct(product) {
+		return errorResponse(stub, newError(codeUnknownProduct, details{"product": product, "products": strings.Join(knownProducts, ", ")}))
+	}
+
+	existing, err := stub.GetState(name)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if existing != nil {
+		return errorResponse(stub, newError(codeAccountExists, details{"account": name}))
+	}
+
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currency, err := currencies.resolve(optional(args, 5))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	digits := currencies.digits(currency)
+	var X uint64
+	if optional(args, 4) != "" {
+		X, err = parseAmount(args[4], digits)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+	}
+
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	acc := &account{ObjectType: "ACCOUNT", Name: name, Balances: map[string]uint64{}, TotalsForDay: map[string]uint64{}, Owner: mspid + "/" + cn, Status: statusOpen, Product: product, Deltas: product == productMerchant}
+	event := accountOpenedEvent{
+		transferEvent: transferEvent{
+			eventHeader:   newEventHeader(stub, eventAccountOpened, today, caller),
+			Credit:        acc.Name,
+			Currency:      currency,
+			Amount:        newAmountValue(0, digits),
+			CreditBalance: newAmountValue(0, digits),
+		},
+		Owner:   acc.Owner,
+		Product: product,
+	}
+
+	// the initial funding is the welcome credit of the bank, within the opening limit
+	if X > 0 {
+		err = checkWelcome(stub, acc.Name)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		limits, err := getLimits(stub)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		openingLimit := limits.openingLimit(acc, currency, digits)
+		if X > openingLimit {
+			return errorResponse(stub, newError(codeOpeningLimitExceeded, details{"account": acc.Name, "amount": args[4], "limit": formatAmount(openingLimit, digits), "currency": currency}))
+		}
+
+		bank, err := getAccount(stub, "MPLBANK")
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		bankBalance, err := debitReserve(stub, bank, currency, X, args[4])
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+
+		acc.Balances[currency] = X
+		err = putTransfer(stub, newTransfer(stub, bank.Name, acc.Name, currency, X, today, now, caller), now)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+
+		event.Debit = bank.Name
+		event.Amount = newAmountValue(X, digits)
+		event.DebitBalance = newAmountValue(bankBalance, digits)
+		event.CreditBalance = newAmountValue(X, digits)
+	}
+
+	err = putAccount(stub, acc)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	OwnerNameIndexKey, err := stub.CreateCompositeKey("owner~name", []string{acc.Owner, acc.Name})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = stub.PutState(OwnerNameIndexKey, []byte{0x00})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = setEvent(stub, eventAccountOpened, event)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkOpen(t *testing.T, stub *shim.MockStub, txid string, args ...string) {
+	bargs := [][]byte{[]byte("openaccount")}
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	res := stub.MockInvoke(txid, bargs)
+	if res.Status != shim.OK {
+		fmt.Println("openaccount", args, "failed", res.Message)
+		t.FailNow()
+	}
+}
+
+// openAccounts opens empty current accounts for Org1MSP/jyg, the bank credits them with a move
+func openAccounts(t *testing.T, stub *shim.MockStub, names ...string) {
+	for _, name := range names {
+		checkOpen(t, stub, "open "+name, name, "Org1MSP", "jyg", productCurrent)
+	}
+}
+
+func TestOpenAccount_Owner(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+
+	// the customer owns the account, not the operator opening it
+	checkOpen(t, stub, "o1", "COMPTE_LUC", "Org2MSP", "luc", productCurrent, "500")
+	var opened accountOpenedEvent
+	checkEvent(t, stub, eventAccountOpened, &opened)
+	if opened.Owner != "Org2MSP/luc" || opened.Product != productCurrent || opened.Debit != "MPLBANK" ||
+		opened.Amount.Amount != "500.00" || opened.CreditBalance.Amount != "500.00" || opened.Requester != "jyg" {
+		fmt.Println("unexpected AccountOpened", opened)
+		t.FailNow()
+	}
+	res := stub.MockInvoke("1", [][]byte{[]byte("query"), []byte("COMPTE_LUC"), []byte("verbose")})
+	var acc accountResponse
+	if err := json.Unmarshal(res.Payload, &acc); err != nil || acc.Owner != "Org2MSP/luc" || acc.Product != productCurrent || acc.Balance != "500.00" {
+		fmt.Println("unexpected account", string(res.Payload))
+		t.FailNow()
+	}
+	checkBalance(t, stub, "MPLBANK", "899500.00")
+	if stub.State["\x00owner~name\x00Org2MSP/luc\x00COMPTE_LUC\x00"] == nil {
+		fmt.Println("no owner index entry for COMPTE_LUC")
+		t.FailNow()
+	}
+	// the funding was the welcome credit
+	checkError(t, stub, codeAlreadyCredited, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_LUC"), []byte("10")})
+
+	// a move does not open accounts, nor overwrite the bank records written later
+	checkError(t, stub, codeAccountNotFound, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_NOUVEAU"), []byte("10")})
+	checkError(t, stub, codeAccountNotFound, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("MPLBANK_WELCOME"), []byte("10")})
+
+	checkOpen(t, stub, "o2", "COMPTE_EMMA", "Org1MSP", "emma", productSavings)
+	checkEvent(t, stub, eventAccountOpened, &opened)
+	if opened.Debit != "" || opened.Amount.Amount != "0.00" || opened.Owner != "Org1MSP/emma" {
+		fmt.Println("unexpected AccountOpened without funding", opened)
+		t.FailNow()
+	}
+	checkBalance(t, stub, "COMPTE_EMMA", "0.00")
+
+	checkOpen(t, stub, "o3", "BOUTIQUE_1", "Org1MSP", "shop", productMerchant, "10", "EUR")
+	var shop account
+	if err := json.Unmarshal(stub.State["BOUTIQUE_1"], &shop); err != nil || !shop.Deltas {
+		fmt.Println("merchant account not in delta mode", string(stub.State["BOUTIQUE_1"]))
+		t.FailNow()
+	}
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+
+	for code, args := range map[string][]string{
+		codeInvalidAccountName:   {"compte_x", "Org1MSP", "x", productCurrent},
+		codeReservedAccountName:  {"MPLBANK_DAY", "Org1MSP", "x", productCurrent},
+		codeAccountExists:        {"COMPTE_LUC", "Org1MSP", "x", productCurrent},
+		codeInvalidOwner:         {"COMPTE_X", "Org1/MSP", "x", productCurrent},
+		codeUnknownProduct:       {"COMPTE_X", "Org1MSP", "x", "gold"},
+		codeOpeningLimitExceeded: {"COMPTE_X", "Org1MSP", "x", productCurrent, "20000"},
+		codeUnknownCurrency:      {"COMPTE_X", "Org1MSP", "x", productCurrent, "10", "USD"},
+	} {
+		bargs := [][]byte{[]byte("openaccount")}
+		for _, a := range args {
+			bargs = append(bargs, []byte(a))
+		}
+		checkError(t, stub, code, bargs)
+	}
+	for _, name := range []string{"AB", "1COMPTE", "COMPTE-X", "COMPTE\x00X"} {
+		checkError(t, stub, codeInvalidAccountName, [][]byte{[]byte("openaccount"), []byte(name), []byte("Org1MSP"), []byte("x"), []byte(productCurrent)})
+	}
+	checkError(t, stub, codeReservedAccountName, [][]byte{[]byte("openaccount"), []byte("MPLBANK"), []byte("Org1MSP"), []byte("x"), []byte(productCurrent)})
+
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkError(t, stub, codeAccessDenied, [][]byte{[]byte("openaccount"), []byte("COMPTE_KARINE"), []byte("Org1MSP"), []byte("karine"), []byte(productCurrent)})
+}
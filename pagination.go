@@ -0,0 +1,144 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Listings return at most pagesize records and a bookmark, the key of the
+// first record of the next page. Passing the bookmark back returns the next
+// page, an empty bookmark means the last page was returned. Without a page
+// size a listing returns every record from the bookmark on.
+
+// largest page a caller can ask for, it keeps responses under the message size limit
+const maxPageSize = 1000
+
+// page is one page of a listing
+type page struct {
+	Records  []*queryresult.KV
+	Bookmark string
+}
+
+// orders of a listing, oldest first unless desc is asked
+const (
+	orderAsc  = "asc"
+	orderDesc = "desc"
+)
+
+// parseOrder validates the optional order argument
+func parseOrder(order string) (string, error) {
+	switch order {
+	case "", orderAsc:
+		return orderAsc, nil
+	case orderDesc:
+		return orderDesc, nil
+	}
+	return "", newError(codeInvalidOrder, details{"order": order})
+}
+
+// parsePageSize validates the optional page size argument, 0 means no pagination
+func parsePageSize(s string) (int32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 || n > maxPageSize {
+		return 0, newError(codeInvalidPageSize, details{"pagesize": s, "max": strconv.Itoa(maxPageSize)})
+	}
+	return int32(n), nil
+}
+
+// rangePage reads a page of the keys between start and end
+func rangePage(stub shim.ChaincodeStubInterface, start string, end string, pageSize int32, bookmark string) (*page, error) {
+	if pageSize > 0 {
+		it, metadata, err := stub.GetStateByRangeWithPagination(start, end, pageSize, bookmark)
+		if err != nil {
+			return nil, err
+		}
+		if it != nil {
+			return readPaginated(it, metadata)
+		}
+	}
+	// no pagination asked, or a stub without paginated iterators such as the mock stub
+	it, err := stub.GetStateByRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return readPage(it, pageSize, bookmark)
+}
+
+// compositePage reads a page of the composite keys of index starting with keys
+func compositePage(stub shim.ChaincodeStubInterface, index string, keys []string, pageSize int32, bookmark string) (*page, error) {
+	if pageSize > 0 {
+		it, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(index, keys, pageSize, bookmark)
+		if err != nil {
+			return nil, err
+		}
+		if it != nil {
+			return readPaginated(it, metadata)
+		}
+	}
+	it, err := stub.GetStateByPartialCompositeKey(index, keys)
+	if err != nil {
+		return nil, err
+	}
+	return readPage(it, pageSize, bookmark)
+}
+
+// readPaginated drains a paginated iterator, the shim already applied the page size and the bookmark
+func readPaginated(it shim.StateQueryIteratorInterface, metadata *pb.QueryResponseMetadata) (*page, error) {
+	defer it.Close()
+	p := &page{Records: []*queryresult.KV{}}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		p.Records = append(p.Records, kv)
+	}
+	if metadata != nil {
+		p.Bookmark = metadata.Bookmark
+	}
+	return p, nil
+}
+
+// readPage skips the keys before the bookmark and reads pageSize records,
+// the bookmark of the page is the key of the record after them
+func readPage(it shim.StateQueryIteratorInterface, pageSize int32, bookmark string) (*page, error) {
+	defer it.Close()
+	p := &page{Records: []*queryresult.KV{}}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if kv.Key < bookmark {
+			continue
+		}
+		if pageSize > 0 && int32(len(p.Records)) == pageSize {
+			p.Bookmark = kv.Key
+			break
+		}
+		p.Records = append(p.Records, kv)
+	}
+	return p, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// listAll follows the bookmarks of a listing and returns the names of every page
+func listAll(t *testing.T, stub *shim.MockStub, function string, pageSize string) [][]string {
+	pages := [][]string{}
+	bookmark := ""
+	for {
+		res := stub.MockInvoke("1", [][]byte{[]byte(function), []byte(""), []byte(pageSize), []byte(bookmark)})
+		if res.Status != shim.OK {
+			fmt.Println(function, "failed", res.Message)
+			t.FailNow()
+		}
+		var resp accountsResponse
+		if err := json.Unmarshal(res.Payload, &resp); err != nil {
+			fmt.Println(function, "returned invalid JSON", string(res.Payload))
+			t.FailNow()
+		}
+		names := []string{}
+		for _, acc := range resp.Accounts {
+			names = append(names, acc.Name)
+		}
+		pages = append(pages, names)
+		if resp.Bookmark == "" {
+			return pages
+		}
+		if len(pages) > 50 {
+			fmt.Println(function, "does not end")
+			t.FailNow()
+		}
+		bookmark = resp.Bookmark
+	}
+}
+
+func TestPagination_Accounts(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	for _, name := range []string{"AC1", "AC2", "AC3", "AC4", "AC5"} {
+		checkOpen(t, stub, "open "+name, name, "Org1MSP", "jyg", productCurrent, "10")
+	}
+
+	// an account opened before identities carried the MSP ID
+	stub.MockTransactionStart("legacy")
+	stub.PutState("A0", []byte(`{"docType":"ACCOUNT","name":"A0","currentbalance":700,"owner":"jyg"}`))
+	key, _ := stub.CreateCompositeKey("owner~name", []string{"jyg", "A0"})
+	stub.PutState(key, []byte{0x00})
+	stub.MockTransactionEnd("legacy")
+
+	// the system keys are read, and counted, but not listed. Unlike a peer
+	// the mock stub also returns the index entries, they come first and
+	// only make empty pages.
+	pages := [][]string{}
+	for _, names := range listAll(t, stub, "getaccounts", "2") {
+		if len(names) > 0 {
+			pages = append(pages, names)
+		}
+	}
+	if fmt.Sprint(pages) != "[[A0] [AC1 AC2] [AC3 AC4] [AC5 MPLBANK]]" {
+		fmt.Println("unexpected pages of getaccounts", pages)
+		t.FailNow()
+	}
+	if fmt.Sprint(listAll(t, stub, "getaccounts", "")) != "[[A0 AC1 AC2 AC3 AC4 AC5 MPLBANK]]" {
+		fmt.Println("getaccounts without a page size did not return every account")
+		t.FailNow()
+	}
+
+	// the pages go on from the accounts of the identity to the legacy ones
+	pages = listAll(t, stub, "getaccountsbyowner", "3")
+	if fmt.Sprint(pages) != "[[AC1 AC2 AC3] [AC4 AC5 MPLBANK] [A0]]" {
+		fmt.Println("unexpected pages of getaccountsbyowner", pages)
+		t.FailNow()
+	}
+	pages = listAll(t, stub, "getaccountsbyowner", "4")
+	if fmt.Sprint(pages) != "[[AC1 AC2 AC3 AC4] [AC5 MPLBANK A0]]" {
+		fmt.Println("unexpected pages of getaccountsbyowner", pages)
+		t.FailNow()
+	}
+
+	checkError(t, stub, codeInvalidPageSize, [][]byte{[]byte("getaccounts"), []byte(""), []byte("0")})
+	checkError(t, stub, codeInvalidPageSize, [][]byte{[]byte("getaccounts"), []byte(""), []byte("1001")})
+	checkError(t, stub, codeInvalidArgument, [][]byte{[]byte("getaccounts"), []byte(""), []byte("ten")})
+	checkError(t, stub, codeInvalidArgument, [][]byte{[]byte("getaccountsbyowner"), []byte(""), []byte("2"), []byte("AC3")})
+
+	// a bookmark cannot start the range in the accounts of another owner
+	other, _ := stub.CreateCompositeKey("owner~name", []string{"Org0MSP/alice"})
+	checkError(t, stub, codeInvalidArgument, [][]byte{[]byte("getaccountsbyowner"), []byte(""), []byte("2"), []byte(other)})
+	other, _ = stub.CreateCompositeKey("owner~name", []string{"Org1MSP/jygx", "AC1"})
+	checkError(t, stub, codeInvalidArgument, [][]byte{[]byte("getaccountsbyowner"), []byte(""), []byte("2"), []byte(other)})
+
+	// the same common name in another MSP does not own the legacy accounts
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org2MSP"), []byte("jyg"), []byte("customer")})
+	setCreator(t, stub, "Org2MSP", "jyg")
+	if pages = listAll(t, stub, "getaccountsbyowner", "3"); fmt.Sprint(pages) != "[[]]" {
+		fmt.Println("unexpected pages of getaccountsbyowner in another MSP", pages)
+		t.FailNow()
+	}
+	legacy, _ := stub.CreateCompositeKey("owner~name", []string{"jyg"})
+	checkError(t, stub, codeInvalidArgument, [][]byte{[]byte("getaccountsbyowner"), []byte(""), []byte("2"), []byte(legacy)})
+}
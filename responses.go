@@ -0,0 +1,229 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Query responses are marshalled from the types below. Field names are
+// lower case, amounts are decimal strings in major units and, in the
+// verbose format, integer strings in minor units. The version only changes
+// when a field is removed or changes meaning.
+const responseVersion = 1
+
+// formats of the query responses, compact is the default
+const (
+	formatCompact = "compact"
+	formatVerbose = "verbose"
+)
+
+// parseFormat validates the optional format argument of a query
+func parseFormat(format string) (string, error) {
+	switch format {
+	case "", formatCompact:
+		return formatCompact, nil
+	case formatVerbose:
+		return formatVerbose, nil
+	}
+	return "", newError(codeInvalidFormat, details{"format": format})
+}
+
+// accountResponse is the result of query
+type accountResponse struct {
+	Version  int               `json:"version"`
+	Name     string            `json:"name"`
+	Currency string            `json:"currency"`       // default currency of the bank
+	Balance  string            `json:"balance"`        // balance in the default currency
+	Balances map[string]string `json:"balances"`       // available balance per currency
+	Held     map[string]string `json:"held,omitempty"` // amount held per currency by pending transfers and holds, not in Balances
+
+	// verbose only
+	Minor        map[string]string `json:"minor,omitempty"` // balance per currency in minor units
+	Owner        string            `json:"owner,omitempty"`
+	Tier         string            `json:"tier,omitempty"`
+	Product      string            `json:"product,omitempty"`
+	Status       string            `json:"status,omitempty"`
+	LastDebitDay string            `json:"lastdebitday,omitempty"`
+	TotalsForDay map[string]string `json:"totalsforday,omitempty"` // amount debited per currency on LastDebitDay
+}
+
+func newAccountResponse(acc *account, c *currencies, format string) *accountResponse {
+	resp := &accountResponse{
+		Version:  responseVersion,
+		Name:     acc.Name,
+		Currency: c.Default,
+		Balance:  c.format(acc.Balances[c.Default], c.Default),
+		Balances: map[string]string{},
+	}
+	for code, amount := range acc.Balances {
+		resp.Balances[code] = c.format(amount, code)
+	}
+	for code, amount := range acc.Held {
+		if amount > 0 {
+			if resp.Held == nil {
+				resp.Held = map[string]string{}
+			}
+			resp.Held[code] = c.format(amount, code)
+		}
+	}
+	if format != formatVerbose {
+		return resp
+	}
+
+	resp.Minor = map[string]string{}
+	for code, amount := range acc.Balances {
+		resp.Minor[code] = c.value(amount, code).Minor
+	}
+	resp.Owner = acc.Owner
+	resp.Tier = acc.Tier
+	resp.Product = acc.Product
+	resp.Status = statusOpen
+	if acc.isClosed() {
+		resp.Status = statusClosed
+	}
+	resp.LastDebitDay = acc.LastDebitDay
+	resp.TotalsForDay = map[string]string{}
+	for code, amount := range acc.TotalsForDay {
+		resp.TotalsForDay[code] = c.format(amount, code)
+	}
+	return resp
+}
+
+// limitResponse is the result of queryplafond
+type limitResponse struct {
+	Version  int    `json:"version"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+	Total    string `json:"total"` // amount debited on the current business day
+	Limit    string `json:"limit"` // daily limit of the account
+
+	// verbose only
+	Remaining   string `json:"remaining,omitempty"` // amount that can still be debited today
+	TotalMinor  string `json:"totalminor,omitempty"`
+	LimitMinor  string `json:"limitminor,omitempty"`
+	BusinessDay string `json:"businessday,omitempty"`
+}
+
+// historyResponse is the result of gethistory
+type historyResponse struct {
+	Version  int            `json:"version"`
+	Name     string         `json:"name"`
+	History  []historyEntry `json:"history"`
+	Fetched  int            `json:"fetched"`            // number of entries in History
+	Bookmark string         `json:"bookmark,omitempty"` // start of the next page, empty on the last one
+}
+
+// historyEntry is the state of the account after one transaction
+type historyEntry struct {
+	TxID      string            `json:"txid"`
+	Timestamp string            `json:"timestamp"` // RFC 3339
+	Deleted   bool              `json:"deleted"`
+	Balance   string            `json:"balance,omitempty"` // balance in the default currency, empty once deleted
+	Balances  map[string]string `json:"balances,omitempty"`
+	Account   *accountResponse  `json:"account,omitempty"`   // verbose view of the account, missing once deleted
+	Memo      string            `json:"memo,omitempty"`      // memo of the move of the transaction
+	Reference string            `json:"reference,omitempty"` // reference of the move of the transaction
+
+	// verbose only
+	Minor  map[string]string `json:"minor,omitempty"`
+	Status string            `json:"status,omitempty"`
+}
+
+// transferResponse is the result of gettransfer
+type transferResponse struct {
+	Version     int    `json:"version"`
+	TxID        string `json:"txid"`
+	Debit       string `json:"debit"`
+	Credit      string `json:"credit"`
+	Currency    string `json:"currency"`
+	Amount      string `json:"amount"`
+	BusinessDay string `json:"businessday"`
+	Timestamp   string `json:"timestamp"`
+	Requester   string `json:"requester"`
+	Memo        string `json:"memo,omitempty"`
+	Reference   string `json:"reference,omitempty"`
+
+	// verbose only
+	Minor string `json:"minor,omitempty"`
+}
+
+func newTransferResponse(tr *transfer, c *currencies, format string) *transferResponse {
+	resp := &transferResponse{
+		Version:     responseVersion,
+		TxID:        tr.TxID,
+		Debit:       tr.Debit,
+		Credit:      tr.Credit,
+		Currency:    tr.Currency,
+		Amount:      c.format(tr.Amount, tr.Currency),
+		BusinessDay: tr.BusinessDay,
+		Timestamp:   tr.Timestamp,
+		Requester:   tr.Requester,
+		Memo:        tr.Memo,
+		Reference:   tr.Reference,
+	}
+	if format == formatVerbose {
+		resp.Minor = c.value(tr.Amount, tr.Currency).Minor
+	}
+	return resp
+}
+
+// transfersResponse is the result of gettransfersforaccount
+type transfersResponse struct {
+	Version   int                 `json:"version"`
+	Name      string              `json:"name"`
+	Transfers []*transferResponse `json:"transfers"`
+	Fetched   int                 `json:"fetched"`            // number of entries in Transfers
+	Bookmark  string              `json:"bookmark,omitempty"` // start of the next page, empty on the last one
+}
+
+// accountListResponse is the result of getaccounts
+type accountListResponse struct {
+	Version  int                `json:"version"`
+	Accounts []*accountResponse `json:"accounts"`
+	Fetched  int                `json:"fetched"`            // number of records read, including the ones that are not accounts or do not match
+	Bookmark string             `json:"bookmark,omitempty"` // start of the next page, empty on the last one
+}
+
+// accountsResponse is the result of getaccountsbyowner
+type accountsResponse struct {
+	Version  int              `json:"version"`
+	Accounts []accountSummary `json:"accounts"`
+	Fetched  int              `json:"fetched"`            // number of records read, index entries and system keys included
+	Bookmark string           `json:"bookmark,omitempty"` // start of the next page, empty on the last one
+}
+
+type accountSummary struct {
+	Name string `json:"name"`
+
+	// verbose only
+	Owner  string `json:"owner,omitempty"`
+	Tier   string `json:"tier,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// respond marshals a response, the errors are internal ones
+func respond(stub shim.ChaincodeStubInterface, resp interface{}) pb.Response {
+	respbytes, err := json.Marshal(resp)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(respbytes)
+}
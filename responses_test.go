@@ -0,0 +1,96 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkResponse(t *testing.T, stub *shim.MockStub, value string, args ...string) {
+	bargs := [][]byte{}
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	res := stub.MockInvoke("1", bargs)
+	if res.Status != shim.OK {
+		fmt.Println(args[0], "failed", res.Message)
+		t.FailNow()
+	}
+	if string(res.Payload) != value {
+		fmt.Println(args[0], "returned", string(res.Payload), "instead of", value)
+		t.FailNow()
+	}
+}
+
+func TestResponses_Formats(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG")
+	// move used to open accounts with any name
+	stub.MockTransactionStart("legacy")
+	stub.PutState(`COMPTE "KARINE"`, []byte(`{"docType":"ACCOUNT","name":"COMPTE \"KARINE\"","balances":{},"totalsforday":{},"owner":"Org1MSP/jyg","status":"OPEN"}`))
+	stub.MockTransactionEnd("legacy")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", `COMPTE "KARINE"`, "100")
+	checkInvoke(t, stub, [][]byte{[]byte("settier"), []byte("COMPTE_JYG"), []byte("gold")})
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte(`COMPTE "KARINE"`), []byte("10.50")})
+
+	checkResponse(t, stub, `{"version":1,"name":"COMPTE_JYG","currency":"EUR","balance":"1989.50","balances":{"EUR":"1989.50"}}`,
+		"query", "COMPTE_JYG")
+	res := stub.MockInvoke("1", [][]byte{[]byte("query"), []byte("COMPTE_JYG"), []byte("verbose")})
+	var acc accountResponse
+	if err := json.Unmarshal(res.Payload, &acc); err != nil || acc.Minor["EUR"] != "198950" || acc.Owner != "Org1MSP/jyg" ||
+		acc.Tier != "gold" || acc.Status != statusOpen || acc.LastDebitDay == "" || acc.TotalsForDay["EUR"] != "10.50" {
+		fmt.Println("unexpected verbose query", string(res.Payload))
+		t.FailNow()
+	}
+
+	// names are escaped
+	checkResponse(t, stub, `{"version":1,"name":"COMPTE \"KARINE\"","currency":"EUR","balance":"110.50","balances":{"EUR":"110.50"}}`,
+		"query", `COMPTE "KARINE"`, "compact")
+	checkResponse(t, stub, `{"version":1,"accounts":[`+
+		`{"version":1,"name":"COMPTE \"KARINE\"","currency":"EUR","balance":"110.50","balances":{"EUR":"110.50"}},`+
+		`{"version":1,"name":"COMPTE_JYG","currency":"EUR","balance":"1989.50","balances":{"EUR":"1989.50"}},`+
+		`{"version":1,"name":"MPLBANK","currency":"EUR","balance":"897900.00","balances":{"EUR":"897900.00"}}],"fetched":20}`,
+		"getaccounts")
+	res = stub.MockInvoke("1", [][]byte{[]byte("getaccounts"), []byte("verbose")})
+	var list accountListResponse
+	if err := json.Unmarshal(res.Payload, &list); err != nil || len(list.Accounts) != 3 || list.Accounts[1].Tier != "gold" ||
+		list.Accounts[1].Minor["EUR"] != "198950" || list.Accounts[2].Status != statusOpen {
+		fmt.Println("unexpected verbose getaccounts", string(res.Payload))
+		t.FailNow()
+	}
+
+	checkResponse(t, stub, `{"version":1,"name":"COMPTE_JYG","currency":"EUR","total":"10.50","limit":"1000.00"}`,
+		"queryplafond", "COMPTE_JYG")
+	res = stub.MockInvoke("1", [][]byte{[]byte("queryplafond"), []byte("COMPTE_JYG"), []byte(""), []byte("verbose")})
+	var limit limitResponse
+	if err := json.Unmarshal(res.Payload, &limit); err != nil || limit.Remaining != "989.50" || limit.TotalMinor != "1050" ||
+		limit.LimitMinor != "100000" || limit.BusinessDay == "" {
+		fmt.Println("unexpected verbose queryplafond", string(res.Payload))
+		t.FailNow()
+	}
+
+	checkError(t, stub, codeInvalidFormat, [][]byte{[]byte("query"), []byte("COMPTE_JYG"), []byte("xml")})
+	checkError(t, stub, codeInvalidFormat, [][]byte{[]byte("getaccountsbyowner"), []byte("full")})
+}
@@ -0,0 +1,403 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// handler is the signature shared by every function reachable through Invoke
+type handler func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response
+
+// argument types understood by the router
+const (
+	argString = "string"
+	argUint64 = "uint64"
+	argAmount = "decimal"   // scale checked against the currency by the handler
+	argTime   = "timestamp" // RFC 3339
+	argDay    = "date"      // e.g. 2017-06-26
+)
+
+type argument struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Optional bool   `json:"optional,omitempty"` // optional arguments come last
+}
+
+// function describes one entry of the registry
+type function struct {
+	Name    string     `json:"name"`
+	Args    []argument `json:"args"`
+	Writes  bool       `json:"writes"`
+	Roles   []string   `json:"roles"` // the caller must hold one of them
+	handler handler
+}
+
+var registry = map[string]*function{}
+
+func register(f *function) {
+	if _, exists := registry[f.Name]; exists {
+		panic("function registered twice: " + f.Name)
+	}
+	if f.Args == nil {
+		f.Args = []argument{}
+	}
+	registry[f.Name] = f
+}
+
+func init() {
+	register(&function{
+		Name:    "move",
+		Args:    []argument{{"debit", argString, false}, {"credit", argString, false}, {"amount", argAmount, false}, {"currency", argString, true}, {"memo", argString, true}, {"reference", argString, true}, {"idempotencykey", argString, true}},
+		Writes:  true,
+		Roles:   []string{roleCustomer, roleTeller, roleBankAdmin},
+		handler: (*SimpleChaincode).invoke,
+	})
+	register(&function{
+		Name:    "openaccount",
+		Args:    []argument{{"name", argString, false}, {"mspid", argString, false}, {"cn", argString, false}, {"product", argString, false}, {"amount", argAmount, true}, {"currency", argString, true}},
+		Writes:  true,
+		Roles:   []string{roleTeller, roleBankAdmin},
+		handler: (*SimpleChaincode).openaccount,
+	})
+	register(&function{
+		Name:    "approvetransfer",
+		Args:    []argument{{"txid", argString, false}},
+		Writes:  true,
+		Roles:   []string{roleApprover},
+		handler: (*SimpleChaincode).approvetransfer,
+	})
+	register(&function{
+		Name:    "rejecttransfer",
+		Args:    []argument{{"txid", argString, false}},
+		Writes:  true,
+		Roles:   []string{roleApprover},
+		handler: (*SimpleChaincode).rejecttransfer,
+	})
+	register(&function{
+		Name:    "expiretransfers",
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).expiretransfers,
+	})
+	register(&function{
+		Name:    "placehold",
+		Args:    []argument{{"name", argString, false}, {"merchant", argString, false}, {"amount", argAmount, false}, {"currency", argString, true}, {"memo", argString, true}},
+		Writes:  true,
+		Roles:   []string{roleCustomer, roleTeller, roleBankAdmin},
+		handler: (*SimpleChaincode).placehold,
+	})
+	register(&function{
+		Name:    "capturehold",
+		Args:    []argument{{"hold", argString, false}, {"amount", argAmount, false}},
+		Writes:  true,
+		Roles:   []string{roleCustomer, roleTeller, roleBankAdmin},
+		handler: (*SimpleChaincode).capturehold,
+	})
+	register(&function{
+		Name:    "releasehold",
+		Args:    []argument{{"hold", argString, false}},
+		Writes:  true,
+		Roles:   []string{roleCustomer, roleTeller, roleBankAdmin},
+		handler: (*SimpleChaincode).releasehold,
+	})
+	register(&function{
+		Name:    "expireholds",
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).expireholds,
+	})
+	register(&function{
+		Name:    "topup",
+		Args:    []argument{{"name", argString, false}, {"amount", argAmount, false}, {"reason", argString, false}, {"currency", argString, true}, {"memo", argString, true}},
+		Writes:  true,
+		Roles:   []string{roleTeller},
+		handler: (*SimpleChaincode).topup,
+	})
+	register(&function{
+		Name:    "closeaccount",
+		Args:    []argument{{"name", argString, false}, {"sweepto", argString, true}},
+		Writes:  true,
+		Roles:   []string{roleCustomer, roleBankAdmin},
+		handler: (*SimpleChaincode).closeaccount,
+	})
+	register(&function{
+		Name:    "changeday",
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).changeday,
+	})
+	register(&function{
+		Name:    "query",
+		Args:    []argument{{"name", argString, false}, {"format", argString, true}},
+		Roles:   []string{roleCustomer, roleTeller, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).query,
+	})
+	register(&function{
+		Name:    "queryplafond",
+		Args:    []argument{{"name", argString, false}, {"currency", argString, true}, {"format", argString, true}},
+		Roles:   []string{roleCustomer, roleTeller, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).queryplafond,
+	})
+	register(&function{
+		Name:    "gethistory",
+		Args:    []argument{{"name", argString, false}, {"format", argString, true}, {"pagesize", argUint64, true}, {"bookmark", argString, true}, {"from", argTime, true}, {"to", argTime, true}, {"order", argString, true}},
+		Roles:   []string{roleCustomer, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).getHistory,
+	})
+	register(&function{
+		Name:    "gettransfer",
+		Args:    []argument{{"txid", argString, false}, {"format", argString, true}},
+		Roles:   []string{roleCustomer, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).gettransfer,
+	})
+	register(&function{
+		Name:    "gettransfersforaccount",
+		Args:    []argument{{"name", argString, false}, {"format", argString, true}, {"pagesize", argUint64, true}, {"bookmark", argString, true}},
+		Roles:   []string{roleCustomer, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).gettransfersforaccount,
+	})
+	register(&function{
+		Name:    "getstatement",
+		Args:    []argument{{"name", argString, false}, {"from", argDay, false}, {"to", argDay, false}, {"currency", argString, true}},
+		Roles:   []string{roleCustomer, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).getstatement,
+	})
+	register(&function{
+		Name:    "gettopups",
+		Args:    []argument{{"day", argDay, false}, {"pagesize", argUint64, true}, {"bookmark", argString, true}},
+		Roles:   []string{roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).gettopups,
+	})
+	register(&function{
+		Name:    "querybalanceat",
+		Args:    []argument{{"name", argString, false}, {"at", argString, false}},
+		Roles:   []string{roleCustomer, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).querybalanceat,
+	})
+	register(&function{
+		Name:    "getaccountsbyowner",
+		Args:    []argument{{"format", argString, true}, {"pagesize", argUint64, true}, {"bookmark", argString, true}},
+		Roles:   []string{roleCustomer, roleTeller, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).getaccountsbyowner,
+	})
+	register(&function{
+		Name:    "getaccounts",
+		Args:    []argument{{"format", argString, true}, {"pagesize", argUint64, true}, {"bookmark", argString, true}, {"filter", argString, true}},
+		Roles:   []string{roleTeller, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).getaccounts,
+	})
+	register(&function{
+		Name:    "queryaccounts",
+		Args:    []argument{{"selector", argString, false}, {"format", argString, true}, {"pagesize", argUint64, true}, {"bookmark", argString, true}},
+		Roles:   []string{roleTeller, roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).queryaccounts,
+	})
+	register(&function{
+		Name:    "auditsupply",
+		Roles:   []string{roleAuditor, roleBankAdmin},
+		handler: (*SimpleChaincode).auditsupply,
+	})
+	register(&function{
+		Name:    "setlimit",
+		Args:    []argument{{"scope", argString, false}, {"target", argString, false}, {"kind", argString, false}, {"value", argAmount, false}, {"currency", argString, true}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).setlimit,
+	})
+	register(&function{
+		Name:    "settier",
+		Args:    []argument{{"name", argString, false}, {"tier", argString, false}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).settier,
+	})
+	register(&function{
+		Name:    "setcalendar",
+		Args:    []argument{{"timezone", argString, false}, {"cutoffhour", argUint64, false}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).setcalendar,
+	})
+	register(&function{
+		Name:    "setidempotencyretention",
+		Args:    []argument{{"days", argUint64, false}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).setidempotencyretention,
+	})
+	register(&function{
+		Name:    "purgeidempotencykeys",
+		Args:    []argument{{"name", argString, true}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).purgeidempotencykeys,
+	})
+	register(&function{
+		Name:    "consolidatereserve",
+		Args:    []argument{{"name", argString, true}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).consolidatereserve,
+	})
+	register(&function{
+		Name:    "setdeltas",
+		Args:    []argument{{"name", argString, false}, {"enabled", argString, false}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).setdeltas,
+	})
+	register(&function{
+		Name:    "setapprovalpolicy",
+		Args:    []argument{{"quorum", argUint64, false}, {"expiry", argUint64, false}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).setapprovalpolicy,
+	})
+	register(&function{
+		Name:    "setholdexpiry",
+		Args:    []argument{{"days", argUint64, false}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).setholdexpiry,
+	})
+	register(&function{
+		Name:    "setwelcome",
+		Args:    []argument{{"enabled", argString, false}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).setwelcome,
+	})
+	register(&function{
+		Name:    "issue",
+		Args:    []argument{{"amount", argAmount, false}, {"currency", argString, false}, {"digits", argUint64, true}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).issue,
+	})
+	register(&function{
+		Name:    "grantrole",
+		Args:    []argument{{"mspid", argString, false}, {"cn", argString, false}, {"role", argString, false}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).grantrole,
+	})
+	register(&function{
+		Name:    "revokerole",
+		Args:    []argument{{"mspid", argString, false}, {"cn", argString, false}, {"role", argString, false}},
+		Writes:  true,
+		Roles:   []string{roleBankAdmin},
+		handler: (*SimpleChaincode).revokerole,
+	})
+	register(&function{
+		Name:    "whoami",
+		Roles:   []string{roleAnyone},
+		handler: (*SimpleChaincode).whoami,
+	})
+	register(&function{
+		Name:    "listfunctions",
+		Roles:   []string{roleAnyone},
+		handler: (*SimpleChaincode).listfunctions,
+	})
+}
+
+// checkArgs validates the arity and the type of every argument
+func (f *function) checkArgs(args []string) error {
+	required := 0
+	for _, a := range f.Args {
+		if !a.Optional {
+			required++
+		}
+	}
+	if len(args) < required || len(args) > len(f.Args) {
+		expected := strconv.Itoa(required)
+		if required != len(f.Args) {
+			expected += " to " + strconv.Itoa(len(f.Args))
+		}
+		return newError(codeWrongArgumentCount, details{"function": f.Name, "expected": expected})
+	}
+	for i, a := range f.Args[:len(args)] {
+		// an empty optional argument stands for a missing one, so that a later one can be given
+		if a.Optional && args[i] == "" {
+			continue
+		}
+		if a.Type == argUint64 {
+			if _, err := strconv.ParseUint(args[i], 10, 64); err != nil {
+				return newError(codeInvalidArgument, details{"function": f.Name, "argument": a.Name, "type": a.Type})
+			}
+		}
+		if a.Type == argAmount && !decimalAmount.MatchString(args[i]) {
+			return newError(codeInvalidArgument, details{"function": f.Name, "argument": a.Name, "type": a.Type})
+		}
+		if a.Type == argTime {
+			if _, err := parseTime(args[i]); err != nil {
+				return newError(codeInvalidArgument, details{"function": f.Name, "argument": a.Name, "type": a.Type})
+			}
+		}
+		if a.Type == argDay {
+			if _, err := time.Parse(dayLayout, args[i]); err != nil {
+				return newError(codeInvalidArgument, details{"function": f.Name, "argument": a.Name, "type": a.Type})
+			}
+		}
+	}
+	return nil
+}
+
+// optional returns the optional argument at index i, empty when it was not given
+func optional(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// checkAccess verifies that the caller holds one of the roles of the function
+func (f *function) checkAccess(caller *identity) error {
+	for _, role := range f.Roles {
+		if role == roleAnyone || caller.hasRole(role) {
+			return nil
+		}
+	}
+	return newError(codeAccessDenied, details{"function": f.Name, "roles": strings.Join(f.Roles, ", ")})
+}
+
+// listfunctions returns the registry so that clients can discover the API
+func (t *SimpleChaincode) listfunctions(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]*function, 0, len(names))
+	for _, name := range names {
+		list = append(list, registry[name])
+	}
+
+	listbytes, err := json.Marshal(list)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(listbytes)
+}
@@ -0,0 +1,70 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestRouter_Reject(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000000")})
+	openAccounts(t, stub, "COMPTE_JYG")
+
+	// unknown function, the old test name
+	checkInvokeFails(t, stub, [][]byte{[]byte("invoke"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("2000")})
+	// bad arity
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("query")})
+	checkInvokeFails(t, stub, [][]byte{[]byte("changeday"), []byte("1")})
+	// bad type
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_JYG"), []byte("abc")})
+}
+
+func TestRouter_ListFunctions(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("listfunctions")})
+	if res.Status != shim.OK {
+		fmt.Println("listfunctions failed", res.Message)
+		t.FailNow()
+	}
+
+	var list []function
+	if err := json.Unmarshal(res.Payload, &list); err != nil {
+		fmt.Println("listfunctions returned invalid JSON", err)
+		t.FailNow()
+	}
+	if len(list) != len(registry) {
+		fmt.Println("listfunctions returned", len(list), "functions, expected", len(registry))
+		t.FailNow()
+	}
+	for _, f := range list {
+		if f.Name == "move" && (len(f.Args) != 7 || !f.Writes) {
+			fmt.Println("unexpected description of move", f)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,468 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// queryaccounts takes a CouchDB Mango selector restricted to the account
+// fields below, e.g. {"owner":"Org1MSP/jyg","balances.EUR":{"$gte":"100.00"}}.
+// Balances are compared as decimal strings in major units of the currency,
+// they are converted to minor units in the query sent to the state database.
+// The indexes used by these queries are in META-INF/statedb/couchdb/indexes,
+// there is one per currency of indexedCurrencies and a selector can only test
+// the balances in those. To select on another currency, add its index there
+// and its code to the list.
+//
+// The state database only sees the account records. For an account in delta
+// mode, see deltas.go, the balances matched are those of its record as of the
+// last consolidation, not the settled ones queryaccounts returns. Run
+// consolidatereserve before selecting on the balances of such an account.
+// Records written before multi-currency balances and closure existed have a
+// currentbalance and no status: they match neither balances nor status until
+// a transaction writes them again, normalized.
+
+// string fields a selector can test, balances.<currency> is the only other one
+var selectorFields = map[string]bool{"name": true, "owner": true, "tier": true, "status": true, "lastdebitday": true}
+
+const balancesPrefix = "balances."
+
+// currencies with a balance index, sorted
+var indexedCurrencies = []string{"CHF", "EUR", "GBP", "JPY", "USD"}
+
+// operators on a field, the $and and $or combinators hold an array of selectors
+var selectorOperators = map[string]bool{
+	"$eq": true, "$ne": true, "$gt": true, "$gte": true, "$lt": true, "$lte": true,
+	"$in": true, "$nin": true, "$exists": true,
+}
+
+// deepest nesting of $and and $or, it keeps the queries cheap for the peer
+const maxSelectorDepth = 4
+
+// selector is a validated selector, every condition holds an operator object
+// whose values are strings, balances in minor units or, for $exists, booleans
+type selector map[string]interface{}
+
+// selectorParser validates a selector given as a string
+type selectorParser struct {
+	source     string
+	currencies *currencies
+}
+
+func (p *selectorParser) fail(format string, a ...interface{}) error {
+	return newError(codeInvalidSelector, details{"selector": p.source, "detail": fmt.Sprintf(format, a...)})
+}
+
+// parseSelector decodes and validates a selector
+func parseSelector(s string, c *currencies) (selector, error) {
+	p := &selectorParser{source: s, currencies: c}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(s)))
+	decoder.UseNumber()
+	var m map[string]interface{}
+	err := decoder.Decode(&m)
+	if err != nil {
+		return nil, p.fail("%s", err.Error())
+	}
+	if m == nil {
+		return nil, p.fail("expecting an object")
+	}
+	return p.selector(m, 0)
+}
+
+func (p *selectorParser) selector(m map[string]interface{}, depth int) (selector, error) {
+	out := selector{}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch {
+		case key == "$and" || key == "$or":
+			if depth == maxSelectorDepth {
+				return nil, p.fail("more than %d nested $and and $or", maxSelectorDepth)
+			}
+			list, ok := m[key].([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, p.fail("%s expects a non-empty array of selectors", key)
+			}
+			subs := []interface{}{}
+			for _, item := range list {
+				sub, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, p.fail("%s expects a non-empty array of selectors", key)
+				}
+				compiled, err := p.selector(sub, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				subs = append(subs, compiled)
+			}
+			out[key] = subs
+		case strings.HasPrefix(key, "$"):
+			return nil, p.fail("unsupported operator %s", key)
+		default:
+			currency, err := p.field(key)
+			if err != nil {
+				return nil, err
+			}
+			out[key], err = p.condition(key, currency, m[key])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// field validates a field name, it returns the currency of a balance
+func (p *selectorParser) field(name string) (string, error) {
+	if selectorFields[name] {
+		return "", nil
+	}
+	if !strings.HasPrefix(name, balancesPrefix) {
+		return "", p.fail("unsupported field %s", name)
+	}
+	code := strings.TrimPrefix(name, balancesPrefix)
+	if code == "" {
+		return "", p.fail("unsupported field %s", name)
+	}
+	currency, err := p.currencies.resolve(code)
+	if err != nil {
+		return "", err
+	}
+	n := sort.SearchStrings(indexedCurrencies, currency)
+	if n == len(indexedCurrencies) || indexedCurrencies[n] != currency {
+		return "", p.fail("no index on %s, balances can be selected in %s", name, strings.Join(indexedCurrencies, ", "))
+	}
+	return currency, nil
+}
+
+// condition validates the condition on a field, a bare value is an $eq
+func (p *selectorParser) condition(field string, currency string, v interface{}) (map[string]interface{}, error) {
+	ops, ok := v.(map[string]interface{})
+	if !ok {
+		ops = map[string]interface{}{"$eq": v}
+	}
+	if len(ops) == 0 {
+		return nil, p.fail("no operator for %s", field)
+	}
+
+	out := map[string]interface{}{}
+	for op, arg := range ops {
+		if !selectorOperators[op] {
+			return nil, p.fail("unsupported operator %s", op)
+		}
+		switch op {
+		case "$exists":
+			b, ok := arg.(bool)
+			if !ok {
+				return nil, p.fail("%s of %s expects a boolean", op, field)
+			}
+			out[op] = b
+		case "$in", "$nin":
+			list, ok := arg.([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, p.fail("%s of %s expects a non-empty array", op, field)
+			}
+			values := []interface{}{}
+			for _, item := range list {
+				value, err := p.value(field, currency, item)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+			out[op] = values
+		default:
+			value, err := p.value(field, currency, arg)
+			if err != nil {
+				return nil, err
+			}
+			out[op] = value
+		}
+	}
+	return out, nil
+}
+
+// value validates an operand, balances are converted to minor units
+func (p *selectorParser) value(field string, currency string, v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, p.fail("%s expects a string", field)
+	}
+	if currency == "" {
+		return s, nil
+	}
+	return p.currencies.parse(s, currency)
+}
+
+// query is the rich query sent to the state database, it only reads accounts
+func (s selector) query() (string, error) {
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{"$and": []interface{}{map[string]interface{}{"docType": "ACCOUNT"}, s}},
+	}
+	querybytes, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return string(querybytes), nil
+}
+
+// match evaluates the selector on a JSON document, as CouchDB would except
+// for strings that are compared byte-wise instead of with ICU collation
+func (s selector) match(doc map[string]interface{}) bool {
+	for key, v := range s {
+		switch key {
+		case "$and":
+			for _, sub := range v.([]interface{}) {
+				if !sub.(selector).match(doc) {
+					return false
+				}
+			}
+		case "$or":
+			matched := false
+			for _, sub := range v.([]interface{}) {
+				if sub.(selector).match(doc) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		default:
+			value, present := lookupField(doc, key)
+			for op, arg := range v.(map[string]interface{}) {
+				if !matchOperator(value, present, op, arg) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// lookupField follows a dotted field name into a document
+func lookupField(doc map[string]interface{}, field string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// matchOperator applies an operator, only $exists matches a missing field
+func matchOperator(value interface{}, present bool, op string, arg interface{}) bool {
+	if op == "$exists" {
+		return present == arg.(bool)
+	}
+	if !present {
+		return false
+	}
+	switch op {
+	case "$in", "$nin":
+		found := false
+		for _, item := range arg.([]interface{}) {
+			if cmp, ok := compareValue(value, item); ok && cmp == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (op == "$in")
+	}
+	cmp, ok := compareValue(value, arg)
+	if !ok {
+		return op == "$ne"
+	}
+	switch op {
+	case "$eq":
+		return cmp == 0
+	case "$ne":
+		return cmp != 0
+	case "$gt":
+		return cmp > 0
+	case "$gte":
+		return cmp >= 0
+	case "$lt":
+		return cmp < 0
+	case "$lte":
+		return cmp <= 0
+	}
+	return false
+}
+
+// compareValue compares a document value with an operand of the same type
+func compareValue(value interface{}, arg interface{}) (int, bool) {
+	switch a := arg.(type) {
+	case string:
+		s, ok := value.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(s, a), true
+	case uint64:
+		n, ok := value.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		u, err := strconv.ParseUint(n.String(), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case u < a:
+			return -1, true
+		case u > a:
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// queryPage reads a page of the accounts matching the selector
+func queryPage(stub shim.ChaincodeStubInterface, s selector, pageSize int32, bookmark string) (*page, error) {
+	query, err := s.query()
+	if err != nil {
+		return nil, err
+	}
+	if pageSize > 0 {
+		it, metadata, err := stub.GetQueryResultWithPagination(query, pageSize, bookmark)
+		if err == nil && it != nil {
+			return readPaginated(it, metadata)
+		}
+		if err != nil && !richQueryUnsupported(err) {
+			return nil, err
+		}
+	} else {
+		it, err := stub.GetQueryResult(query)
+		if err == nil {
+			return readPage(it, 0, "")
+		}
+		if !richQueryUnsupported(err) {
+			return nil, err
+		}
+	}
+	// state databases without rich queries, LevelDB and the mock stub
+	return evaluatePage(stub, s, pageSize, bookmark)
+}
+
+// richQueryUnsupported tells whether a query failed because the state
+// database has no rich queries. LevelDB answers "ExecuteQuery not supported
+// for leveldb", the mock stub "not implemented" or, with pagination, nothing.
+func richQueryUnsupported(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "not supported for leveldb") || msg == "not implemented"
+}
+
+// evaluatePage scans every record and evaluates the selector on the
+// accounts, the bookmark of the page is the key of the next match
+func evaluatePage(stub shim.ChaincodeStubInterface, s selector, pageSize int32, bookmark string) (*page, error) {
+	it, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	p := &page{Records: []*queryresult.KV{}}
+	for it.HasNext() {
+		kv, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if kv.Key < bookmark || !isAccountRecord(kv.Value) {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(kv.Value))
+		decoder.UseNumber()
+		var doc map[string]interface{}
+		err = decoder.Decode(&doc)
+		if err != nil {
+			return nil, err
+		}
+		if !s.match(doc) {
+			continue
+		}
+		if pageSize > 0 && int32(len(p.Records)) == pageSize {
+			p.Bookmark = kv.Key
+			break
+		}
+		p.Records = append(p.Records, kv)
+	}
+	return p, nil
+}
+
+// queryaccounts lists the accounts matching a selector, args are the
+// selector and an optional format, page size and bookmark. The bookmark
+// is opaque, it is the one returned by the previous page.
+func (t *SimpleChaincode) queryaccounts(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	format, err := parseFormat(optional(args, 1))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	pageSize, err := parsePageSize(optional(args, 2))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	s, err := parseSelector(args[0], currencies)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	p, err := queryPage(stub, s, pageSize, optional(args, 3))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	resp := &accountListResponse{Version: responseVersion, Accounts: []*accountResponse{}, Fetched: len(p.Records), Bookmark: p.Bookmark}
+	for _, kv := range p.Records {
+		acc, err := decodeAccount(stub, kv.Key, kv.Value)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		err = settle(stub, acc)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		resp.Accounts = append(resp.Accounts, newAccountResponse(acc, currencies, format))
+	}
+	return respond(stub, resp)
+}
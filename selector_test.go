@@ -0,0 +1,208 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestSelector_Parse(t *testing.T) {
+	c := newCurrencies("EUR", 2)
+
+	s, err := parseSelector(`{"owner":"Org1MSP/jyg","$or":[{"balances.EUR":{"$gte":"10.50"}},{"status":{"$in":["CLOSED"]}}]}`, c)
+	if err != nil {
+		fmt.Println("valid selector rejected", err)
+		t.FailNow()
+	}
+	query, _ := s.query()
+	expected := `{"selector":{"$and":[{"docType":"ACCOUNT"},{"$or":[{"balances.EUR":{"$gte":1050}},{"status":{"$in":["CLOSED"]}}],"owner":{"$eq":"Org1MSP/jyg"}}]}}`
+	if query != expected {
+		fmt.Println("query", query, "instead of", expected)
+		t.FailNow()
+	}
+
+	for _, invalid := range []string{
+		``,
+		`null`,
+		`[]`,
+		`{"docType":"LIMITS"}`,
+		`{"currentbalance":{"$gt":"0"}}`,
+		`{"balances.":"1"}`,
+		`{"owner":{"$regex":"^Org1"}}`,
+		`{"$not":{"owner":"jyg"}}`,
+		`{"owner":{}}`,
+		`{"owner":1}`,
+		`{"owner":{"$exists":"yes"}}`,
+		`{"status":{"$in":[]}}`,
+		`{"$and":{"owner":"jyg"}}`,
+		`{"$or":[]}`,
+		`{"$or":["jyg"]}`,
+		`{"$and":[{"$and":[{"$and":[{"$and":[{"$and":[{"owner":"jyg"}]}]}]}]}]}`,
+	} {
+		_, err := parseSelector(invalid, c)
+		if !isError(err, codeInvalidSelector) {
+			fmt.Println("selector", invalid, "returned", err)
+			t.FailNow()
+		}
+	}
+	if _, err := parseSelector(`{"balances.USD":{"$gt":"1"}}`, c); !isError(err, codeUnknownCurrency) {
+		fmt.Println("selector on an unknown currency returned", err)
+		t.FailNow()
+	}
+	c.Codes, c.Digits["XOF"] = append(c.Codes, "XOF"), 0
+	if _, err := parseSelector(`{"balances.XOF":{"$gt":"1"}}`, c); !isError(err, codeInvalidSelector) {
+		fmt.Println("selector on a currency without index returned", err)
+		t.FailNow()
+	}
+	if _, err := parseSelector(`{"balances.EUR":{"$gt":"1.001"}}`, c); !isError(err, codeTooManyDecimals) {
+		fmt.Println("selector with too many decimals returned", err)
+		t.FailNow()
+	}
+}
+
+func TestSelector_Match(t *testing.T) {
+	c := newCurrencies("EUR", 2)
+	doc := map[string]interface{}{}
+	decoder := json.NewDecoder(strings.NewReader(`{"docType":"ACCOUNT","name":"AC1","balances":{"EUR":1050},"owner":"Org1MSP/jyg"}`))
+	decoder.UseNumber()
+	decoder.Decode(&doc)
+
+	for selector, expected := range map[string]bool{
+		`{}`:                                             true,
+		`{"name":"AC1"}`:                                  true,
+		`{"name":{"$ne":"AC1"}}`:                          false,
+		`{"balances.EUR":"10.50"}`:                       true,
+		`{"balances.EUR":{"$gt":"10.50"}}`:               false,
+		`{"balances.EUR":{"$gte":"10.50","$lt":"11"}}`:   true,
+		`{"name":{"$in":["A0","AC1"]}}`:                   true,
+		`{"name":{"$nin":["A0","AC1"]}}`:                  false,
+		`{"tier":{"$exists":false}}`:                     true,
+		`{"tier":{"$ne":"gold"}}`:                        false,
+		`{"status":{"$exists":true}}`:                    false,
+		`{"$or":[{"name":"A0"},{"owner":"Org1MSP/jyg"}]}`: true,
+		`{"$and":[{"name":"AC1"},{"tier":"gold"}]}`:       false,
+		`{"name":{"$lt":"AC2"},"owner":{"$gt":"Org1"}}`:   true,
+	} {
+		s, err := parseSelector(selector, c)
+		if err != nil || s.match(doc) != expected {
+			fmt.Println("selector", selector, "did not return", expected, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestSelector_QueryAccounts(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	for i, name := range []string{"AC1", "AC2", "AC3", "AC4", "AC5"} {
+		checkOpen(t, stub, "open "+name, name, "Org1MSP", "jyg", productCurrent, fmt.Sprint(10*(i+1)))
+	}
+	checkInvoke(t, stub, [][]byte{[]byte("closeaccount"), []byte("AC5"), []byte("AC4")})
+
+	// the mock stub has no rich queries, the selector is evaluated in memory
+	checkResponse(t, stub, `{"version":1,"accounts":[{"version":1,"name":"AC4","currency":"EUR","balance":"90.00","balances":{"EUR":"90.00"}}],"fetched":1}`,
+		"queryaccounts", `{"balances.EUR":{"$gt":"30","$lt":"1000"}}`)
+
+	pages := [][]string{}
+	bookmark := ""
+	for len(pages) < 10 {
+		res := stub.MockInvoke("1", [][]byte{[]byte("queryaccounts"), []byte(`{"status":"OPEN","owner":"Org1MSP/jyg"}`), []byte(""), []byte("2"), []byte(bookmark)})
+		var resp accountListResponse
+		if err := json.Unmarshal(res.Payload, &resp); err != nil {
+			fmt.Println("queryaccounts returned", res.Message)
+			t.FailNow()
+		}
+		names := []string{}
+		for _, acc := range resp.Accounts {
+			names = append(names, acc.Name)
+		}
+		pages = append(pages, names)
+		if resp.Bookmark == "" {
+			break
+		}
+		bookmark = resp.Bookmark
+	}
+	if fmt.Sprint(pages) != "[[AC1 AC2] [AC3 AC4] [MPLBANK]]" {
+		fmt.Println("unexpected pages of queryaccounts", pages)
+		t.FailNow()
+	}
+
+	// a legacy record matches once written again, normalized
+	stub.MockTransactionStart("legacy")
+	stub.PutState("LEGACY", []byte(`{"docType":"ACCOUNT","name":"LEGACY","currentbalance":50,"owner":"Org1MSP/jyg"}`))
+	stub.MockTransactionEnd("legacy")
+	checkResponse(t, stub, `{"version":1,"accounts":[],"fetched":0}`, "queryaccounts", `{"name":"LEGACY","status":"OPEN"}`)
+	checkMove(t, stub, "t1", "AC1", "LEGACY", "1")
+	checkResponse(t, stub, `{"version":1,"accounts":[{"version":1,"name":"LEGACY","currency":"EUR","balance":"51.00","balances":{"EUR":"51.00"}}],"fetched":1}`,
+		"queryaccounts", `{"name":"LEGACY","status":"OPEN","balances.EUR":{"$gt":"50"}}`)
+
+	checkError(t, stub, codeInvalidSelector, [][]byte{[]byte("queryaccounts"), []byte(`{"docType":"SUPPLY"}`)})
+	checkError(t, stub, codeInvalidPageSize, [][]byte{[]byte("queryaccounts"), []byte(`{}`), []byte(""), []byte("0")})
+
+	// customers list their own accounts with getaccountsbyowner
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkError(t, stub, codeAccessDenied, [][]byte{[]byte("queryaccounts"), []byte(`{}`)})
+}
+
+// queryStub answers rich queries with an error, like a peer
+type queryStub struct {
+	*shim.MockStub
+	err error
+}
+
+func (stub *queryStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, stub.err
+}
+
+func (stub *queryStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, stub.err
+}
+
+func TestSelector_QueryErrors(t *testing.T) {
+	s, err := parseSelector(`{"status":"OPEN"}`, newCurrencies("EUR", 2))
+	if err != nil {
+		t.FailNow()
+	}
+
+	// only the state databases without rich queries fall back to a scan
+	stub := &queryStub{MockStub: shim.NewMockStub("ex02", new(SimpleChaincode))}
+	for msg, fallback := range map[string]bool{
+		"ExecuteQuery not supported for leveldb":             true,
+		"ExecuteQueryWithMetadata not supported for leveldb": true,
+		"not implemented":                                    true,
+		"Error handling CouchDB request: invalid_operator":   false,
+		"timeout expired while executing transaction":        false,
+	} {
+		stub.err = errors.New(msg)
+		for _, pageSize := range []int32{0, 2} {
+			_, err := queryPage(stub, s, pageSize, "")
+			if (err == nil) != fallback {
+				fmt.Println("query failing with", msg, "returned", err)
+				t.FailNow()
+			}
+		}
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// A statement is built from the history of the account, so that every
+// balance change is listed, sweeps and issues included, and the lines add up
+// from the opening to the closing balance. The counterparty, the memo and
+// the reference come from the transfer record of the transaction, when it is
+// a move.
+//
+// The balances of a statement are booked balances, the available balance
+// plus the amounts held. A hold or a pending transfer is not a line, the
+// capture or the approval that executes it is.
+
+// kinds of statement lines
+const (
+	lineCredit = "credit"
+	lineDebit  = "debit"
+)
+
+// statementResponse is the result of getstatement, amounts are in Currency
+type statementResponse struct {
+	Version        int             `json:"version"`
+	Name           string          `json:"name"`
+	Currency       string          `json:"currency"`
+	From           string          `json:"from"` // first day of the statement, included
+	To             string          `json:"to"`   // last day of the statement, included
+	OpeningBalance string          `json:"openingbalance"`
+	Lines          []statementLine `json:"lines"`
+	TotalCredits   string          `json:"totalcredits"`
+	TotalDebits    string          `json:"totaldebits"`
+	ClosingBalance string          `json:"closingbalance"`
+}
+
+// statementLine is one balance change of the account
+type statementLine struct {
+	TxID         string `json:"txid"`
+	Timestamp    string `json:"timestamp"` // RFC 3339
+	Type         string `json:"type"`      // credit or debit
+	Amount       string `json:"amount"`
+	Balance      string `json:"balance"`                // balance after the line
+	Counterparty string `json:"counterparty,omitempty"` // other account of a move
+	Memo         string `json:"memo,omitempty"`
+	Reference    string `json:"reference,omitempty"`
+}
+
+// getstatement lists the credits and debits of an account between two days
+// of the bank calendar, args are the account, the first and the last day,
+// e.g. 2017-06-01 and 2017-06-30, and an optional currency
+func (t *SimpleChaincode) getstatement(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if !caller.reads(acc) {
+		return errorResponse(stub, newError(codeNotOwner, details{"account": acc.Name}))
+	}
+	if acc.Deltas {
+		return errorResponse(stub, newError(codeDeltaMode, details{"function": "getstatement", "account": acc.Name}))
+	}
+
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currency, err := currencies.resolve(optional(args, 3))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	cal, err := getCalendar(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	// business days, they end at the cut-off hour
+	from, err := cal.dayStart(args[1])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	end, err := cal.dayEnd(args[2])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if !end.After(from) {
+		return errorResponse(stub, newError(codeInvalidPeriod, details{"from": args[1], "to": args[2]}))
+	}
+
+	resultsIterator, err := stub.GetHistoryForKey(acc.Name)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	defer resultsIterator.Close()
+
+	resp := &statementResponse{Version: responseVersion, Name: acc.Name, Currency: currency, From: args[1], To: args[2], Lines: []statementLine{}}
+	var balance, credits, debits uint64
+	var opening uint64
+	for resultsIterator.HasNext() {
+		historicValue, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		ts := versionTime(historicValue)
+		if !ts.Before(end) {
+			break
+		}
+
+		// a deleted account holds nothing
+		var next uint64
+		if !historicValue.IsDelete {
+			var version account
+			err = json.Unmarshal(historicValue.Value, &version)
+			if err != nil {
+				return errorResponse(stub, fmt.Errorf("Failed to decode JSON of: %s", acc.Name))
+			}
+			err = version.normalize(currencies)
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+			next, err = addAmount(version.Balances[currency], version.Held[currency])
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+		}
+
+		if ts.Before(from) {
+			balance, opening = next, next
+			continue
+		}
+		if next == balance {
+			continue
+		}
+
+		line := statementLine{TxID: historicValue.TxId, Timestamp: ts.Format(time.RFC3339Nano), Balance: currencies.format(next, currency)}
+		if next > balance {
+			line.Type = lineCredit
+			line.Amount = currencies.format(next-balance, currency)
+			credits, err = addAmount(credits, next-balance)
+		} else {
+			line.Type = lineDebit
+			line.Amount = currencies.format(balance-next, currency)
+			debits, err = addAmount(debits, balance-next)
+		}
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+
+		tr, err := getLineTransfer(stub, acc.Name, historicValue.TxId, ts)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		if tr != nil {
+			line.Counterparty = tr.Debit
+			if tr.Debit == acc.Name {
+				line.Counterparty = tr.Credit
+			}
+			line.Memo, line.Reference = tr.Memo, tr.Reference
+		}
+
+		resp.Lines = append(resp.Lines, line)
+		balance = next
+	}
+
+	resp.OpeningBalance = currencies.format(opening, currency)
+	resp.TotalCredits = currencies.format(credits, currency)
+	resp.TotalDebits = currencies.format(debits, currency)
+	resp.ClosingBalance = currencies.format(balance, currency)
+	return respond(stub, resp)
+}
+
+// getLineTransfer returns the transfer behind a balance change of the
+// account, or nil. An approval executes the transfer under the TxID of the
+// move, it is found in the account~transfer index at the time of the change.
+func getLineTransfer(stub shim.ChaincodeStubInterface, name string, txid string, ts time.Time) (*transfer, error) {
+	tr, err := getTransfer(stub, txid)
+	if err == nil || !isError(err, codeTransferNotFound) {
+		return tr, err
+	}
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(accountTransferIndex, []string{name, ts.UTC().Format(transferTimeLayout)})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+	if !resultsIterator.HasNext() {
+		return nil, nil
+	}
+	kv, err := resultsIterator.Next()
+	if err != nil {
+		return nil, err
+	}
+	_, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+	if err != nil {
+		return nil, err
+	}
+	return getTransfer(stub, compositeKeyParts[2])
+}
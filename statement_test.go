@@ -0,0 +1,144 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkStatement(t *testing.T, stub *historyStub, expected string, args ...string) {
+	res := stub.run("q", time.Now(), false, append([]string{"getstatement"}, args...)...)
+	if res.Status != shim.OK {
+		fmt.Println("getstatement", args, "failed", res.Message)
+		t.FailNow()
+	}
+	if string(res.Payload) != expected {
+		fmt.Println("getstatement returned", string(res.Payload), "instead of", expected)
+		t.FailNow()
+	}
+}
+
+func checkStatementError(t *testing.T, stub *historyStub, code string, args ...string) {
+	res := stub.run("q", time.Now(), false, append([]string{"getstatement"}, args...)...)
+	var e chaincodeError
+	if json.Unmarshal(res.Payload, &e) != nil || e.Code != code {
+		fmt.Println("getstatement", args, "returned", res.Message, "instead of", code)
+		t.FailNow()
+	}
+}
+
+func TestStatement_Lines(t *testing.T) {
+	stub := newHistoryStub(t)
+	day := time.Date(2017, 5, 31, 9, 0, 0, 0, time.UTC)
+
+	if res := stub.run("init", day, true, "init", "900000"); res.Status != shim.OK {
+		fmt.Println("Init failed", res.Message)
+		t.FailNow()
+	}
+	stub.checkRun(t, "t1", day.Add(time.Hour), "openaccount", "COMPTE_JYG", "Org1MSP", "jyg", productCurrent, "2000")
+	stub.checkRun(t, "t2", day.AddDate(0, 0, 1), "openaccount", "COMPTE_KARINE", "Org1MSP", "jyg", productCurrent, "100")
+	stub.checkRun(t, "t3", day.AddDate(0, 0, 15), "move", "COMPTE_JYG", "COMPTE_KARINE", "10.50", "", "loyer juin", "LOYER-2017-06")
+	stub.checkRun(t, "t4", day.AddDate(0, 0, 20), "move", "COMPTE_KARINE", "COMPTE_JYG", "5")
+	stub.checkRun(t, "t5", day.AddDate(0, 0, 31), "move", "COMPTE_JYG", "COMPTE_KARINE", "1")
+
+	checkStatement(t, stub, `{"version":1,"name":"COMPTE_JYG","currency":"EUR","from":"2017-06-01","to":"2017-06-30",`+
+		`"openingbalance":"2000.00","lines":[`+
+		`{"txid":"t3","timestamp":"2017-06-15T09:00:00Z","type":"debit","amount":"10.50","balance":"1989.50","counterparty":"COMPTE_KARINE","memo":"loyer juin","reference":"LOYER-2017-06"},`+
+		`{"txid":"t4","timestamp":"2017-06-20T09:00:00Z","type":"credit","amount":"5.00","balance":"1994.50","counterparty":"COMPTE_KARINE"}],`+
+		`"totalcredits":"5.00","totaldebits":"10.50","closingbalance":"1994.50"}`,
+		"COMPTE_JYG", "2017-06-01", "2017-06-30")
+
+	// the account opened within the period starts from zero, a single day is a period
+	checkStatement(t, stub, `{"version":1,"name":"COMPTE_KARINE","currency":"EUR","from":"2017-06-01","to":"2017-06-01",`+
+		`"openingbalance":"0.00","lines":[`+
+		`{"txid":"t2","timestamp":"2017-06-01T09:00:00Z","type":"credit","amount":"100.00","balance":"100.00","counterparty":"MPLBANK"}],`+
+		`"totalcredits":"100.00","totaldebits":"0.00","closingbalance":"100.00"}`,
+		"COMPTE_KARINE", "2017-06-01", "2017-06-01")
+
+	// the days follow the time zone of the bank, t2 is on May 31st twelve hours west of UTC
+	stub.checkRun(t, "cal", day.AddDate(0, 0, 40), "setcalendar", "Etc/GMT+12", "0")
+	checkStatement(t, stub, `{"version":1,"name":"COMPTE_KARINE","currency":"EUR","from":"2017-05-31","to":"2017-05-31",`+
+		`"openingbalance":"0.00","lines":[`+
+		`{"txid":"t2","timestamp":"2017-06-01T09:00:00Z","type":"credit","amount":"100.00","balance":"100.00","counterparty":"MPLBANK"}],`+
+		`"totalcredits":"100.00","totaldebits":"0.00","closingbalance":"100.00"}`,
+		"COMPTE_KARINE", "2017-05-31", "2017-05-31")
+
+	checkStatementError(t, stub, codeInvalidPeriod, "COMPTE_JYG", "2017-06-30", "2017-06-01")
+	checkStatementError(t, stub, codeInvalidArgument, "COMPTE_JYG", "2017-06-01", "30/06/2017")
+	checkStatementError(t, stub, codeUnknownCurrency, "COMPTE_JYG", "2017-06-01", "2017-06-30", "USD")
+	checkStatementError(t, stub, codeAccountNotFound, "COMPTE_INCONNU", "2017-06-01", "2017-06-30")
+	stub.checkRun(t, "t6", day.AddDate(0, 0, 32), "setdeltas", "MPLBANK", "true")
+	checkStatementError(t, stub, codeDeltaMode, "MPLBANK", "2017-06-01", "2017-06-30")
+
+	// only the owner, the auditors and the bank admins see a statement
+	stub.checkRun(t, "g1", day.AddDate(0, 0, 40), "grantrole", "Org1MSP", "karine", "customer")
+	stub.checkRun(t, "g2", day.AddDate(0, 0, 40), "grantrole", "Org1MSP", "estelle", "auditor")
+	setCreator(t, stub.MockStub, "Org1MSP", "karine")
+	checkStatementError(t, stub, codeNotOwner, "COMPTE_JYG", "2017-06-01", "2017-06-30")
+	setCreator(t, stub.MockStub, "Org1MSP", "estelle")
+	res := stub.run("q", time.Now(), false, "getstatement", "COMPTE_JYG", "2017-06-01", "2017-06-30")
+	if res.Status != shim.OK {
+		fmt.Println("getstatement failed for an auditor", res.Message)
+		t.FailNow()
+	}
+}
+
+func TestStatement_Booked(t *testing.T) {
+	stub := newHistoryStub(t)
+	day := time.Date(2017, 6, 1, 8, 0, 0, 0, time.UTC)
+
+	if res := stub.run("init", day, true, "init", "900000"); res.Status != shim.OK {
+		fmt.Println("Init failed", res.Message)
+		t.FailNow()
+	}
+	stub.checkRun(t, "cal", day, "setcalendar", "UTC", "18")
+	stub.checkRun(t, "o1", day, "openaccount", "COMPTE_JYG", "Org1MSP", "jyg", productCurrent)
+	stub.checkRun(t, "o2", day, "openaccount", "COMPTE_KARINE", "Org1MSP", "karine", productCurrent)
+	stub.checkRun(t, "g1", day, "grantrole", "Org1MSP", "alice", "approver")
+	stub.checkRun(t, "l1", day, "setlimit", "default", "", "approval", "250")
+	stub.checkRun(t, "q1", day, "setapprovalpolicy", "1", "5")
+	stub.checkRun(t, "t1", day.Add(time.Hour), "move", "MPLBANK", "COMPTE_JYG", "2000")
+
+	// holds and pending transfers are booked when captured or approved
+	stub.checkRun(t, "h1", day.Add(2*time.Hour), "placehold", "COMPTE_JYG", "COMPTE_KARINE", "80")
+	stub.checkRun(t, "c1", day.AddDate(0, 0, 1), "capturehold", "h1", "80")
+	stub.checkRun(t, "p1", day.AddDate(0, 0, 2), "move", "COMPTE_JYG", "COMPTE_KARINE", "300", "", "Achat voiture")
+	setCreator(t, stub.MockStub, "Org1MSP", "alice")
+	stub.checkRun(t, "a1", day.AddDate(0, 0, 3), "approvetransfer", "p1")
+	setCreator(t, stub.MockStub, "Org1MSP", "jyg")
+	stub.checkRun(t, "h2", day.AddDate(0, 0, 4), "placehold", "COMPTE_JYG", "COMPTE_KARINE", "50")
+
+	// after the cut-off hour of June 30th the move belongs to July 1st
+	stub.checkRun(t, "t2", time.Date(2017, 6, 30, 19, 0, 0, 0, time.UTC), "move", "COMPTE_JYG", "COMPTE_KARINE", "5")
+
+	checkStatement(t, stub, `{"version":1,"name":"COMPTE_JYG","currency":"EUR","from":"2017-06-01","to":"2017-06-30",`+
+		`"openingbalance":"0.00","lines":[`+
+		`{"txid":"t1","timestamp":"2017-06-01T09:00:00Z","type":"credit","amount":"2000.00","balance":"2000.00","counterparty":"MPLBANK"},`+
+		`{"txid":"c1","timestamp":"2017-06-02T08:00:00Z","type":"debit","amount":"80.00","balance":"1920.00","counterparty":"COMPTE_KARINE"},`+
+		`{"txid":"a1","timestamp":"2017-06-04T08:00:00Z","type":"debit","amount":"300.00","balance":"1620.00","counterparty":"COMPTE_KARINE","memo":"Achat voiture"}],`+
+		`"totalcredits":"2000.00","totaldebits":"380.00","closingbalance":"1620.00"}`,
+		"COMPTE_JYG", "2017-06-01", "2017-06-30")
+	checkStatement(t, stub, `{"version":1,"name":"COMPTE_JYG","currency":"EUR","from":"2017-07-01","to":"2017-07-01",`+
+		`"openingbalance":"1620.00","lines":[`+
+		`{"txid":"t2","timestamp":"2017-06-30T19:00:00Z","type":"debit","amount":"5.00","balance":"1615.00","counterparty":"COMPTE_KARINE"}],`+
+		`"totalcredits":"0.00","totaldebits":"5.00","closingbalance":"1615.00"}`,
+		"COMPTE_JYG", "2017-07-01", "2017-07-01")
+}
@@ -0,0 +1,161 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// the money issued by the bank, the balances of all accounts must add up to it
+const supplyKey = "MPLBANK_SUPPLY"
+
+// supply is only changed by the functions creating money, Init and issue.
+// Moves and sweeps transfer money between accounts and leave it unchanged.
+type supply struct {
+	ObjectType string            `json:"docType"`
+	Totals     map[string]uint64 `json:"totals"` // minor units issued per currency
+}
+
+func newSupply() *supply {
+	return &supply{ObjectType: "SUPPLY", Totals: map[string]uint64{}}
+}
+
+// getSupply reads the supply record, ledgers created before it existed have an empty one
+func getSupply(stub shim.ChaincodeStubInterface) (*supply, error) {
+	supplybytes, err := stub.GetState(supplyKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", supplyKey)
+	}
+	s := newSupply()
+	if supplybytes == nil {
+		return s, nil
+	}
+	err = json.Unmarshal(supplybytes, s)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", supplyKey)
+	}
+	if s.Totals == nil {
+		s.Totals = map[string]uint64{}
+	}
+	return s, nil
+}
+
+func putSupply(stub shim.ChaincodeStubInterface, s *supply) error {
+	supplybytes, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(supplyKey, supplybytes)
+}
+
+// mint records amount minor units of currency put into circulation
+func (s *supply) mint(currency string, amount uint64) error {
+	total, err := addAmount(s.Totals[currency], amount)
+	if err != nil {
+		return err
+	}
+	s.Totals[currency] = total
+	return nil
+}
+
+// supplyLine compares the recorded supply of a currency with the sum of the balances
+type supplyLine struct {
+	Currency string `json:"currency"`
+	Supply   string `json:"supply"`
+	Accounts string `json:"accounts"`
+	Drift    string `json:"drift"` // accounts minus supply, negative when money is missing
+}
+
+// supplyAudit is the result of auditsupply
+type supplyAudit struct {
+	Version    int          `json:"version"`
+	Consistent bool         `json:"consistent"`
+	Currencies []supplyLine `json:"currencies"`
+}
+
+// auditsupply adds up the balances of every account and reports, per
+// currency, how far the total is from the recorded supply
+func (t *SimpleChaincode) auditsupply(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	s, err := getSupply(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	resultsIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	defer resultsIterator.Close()
+
+	sums := map[string]uint64{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+
+		// skip the configuration records and the index entries
+		if !isAccountRecord(kv.Value) {
+			continue
+		}
+		acc, err := decodeAccount(stub, kv.Key, kv.Value)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		err = settle(stub, acc)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		for _, balances := range []map[string]uint64{acc.Balances, acc.Held} {
+			for code, amount := range balances {
+				sums[code], err = addAmount(sums[code], amount)
+				if err != nil {
+					return errorResponse(stub, err)
+				}
+			}
+		}
+	}
+
+	audit := supplyAudit{Version: responseVersion, Consistent: true, Currencies: []supplyLine{}}
+	for _, code := range currencies.Codes {
+		digits := currencies.digits(code)
+		issued, held := s.Totals[code], sums[code]
+		drift := formatAmount(held-issued, digits)
+		if held < issued {
+			drift = "-" + formatAmount(issued-held, digits)
+		}
+		if held != issued {
+			audit.Consistent = false
+		}
+		audit.Currencies = append(audit.Currencies, supplyLine{code, formatAmount(issued, digits), formatAmount(held, digits), drift})
+	}
+
+	auditbytes, err := json.Marshal(audit)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(auditbytes)
+}
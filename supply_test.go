@@ -0,0 +1,109 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkAudit(t *testing.T, stub *shim.MockStub, value string) {
+	res := stub.MockInvoke("1", [][]byte{[]byte("auditsupply")})
+	if res.Status != shim.OK {
+		fmt.Println("auditsupply failed", res.Message)
+		t.FailNow()
+	}
+	if string(res.Payload) != value {
+		fmt.Println("auditsupply returned", string(res.Payload), "instead of", value)
+		t.FailNow()
+	}
+}
+
+func TestSupply_Checked(t *testing.T) {
+	if _, err := addAmount(math.MaxUint64, 1); err != errOverflow {
+		fmt.Println("addAmount did not overflow")
+		t.FailNow()
+	}
+	if _, err := subAmount(1, 2); err != errOverflow {
+		fmt.Println("subAmount did not underflow")
+		t.FailNow()
+	}
+	if v, err := addAmount(math.MaxUint64-1, 1); err != nil || v != math.MaxUint64 {
+		fmt.Println("addAmount failed at the limit", v, err)
+		t.FailNow()
+	}
+}
+
+func TestSupply_Init(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	for _, args := range [][]string{{"abc"}, {"-5"}, {"0"}, {"1.234"}, {"100", "eur"}, {"100", "EUR", "9"}, {"100", "EUR", "x"}} {
+		bargs := [][]byte{[]byte("init")}
+		for _, a := range args {
+			bargs = append(bargs, []byte(a))
+		}
+		if res := stub.MockInit("1", bargs); res.Status == shim.OK {
+			fmt.Println("Init accepted", args)
+			t.FailNow()
+		}
+	}
+	if stub.State["MPLBANK"] != nil {
+		fmt.Println("a rejected Init created the bank")
+		t.FailNow()
+	}
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("9000")})
+	checkState(t, stub, "MPLBANK_SUPPLY", `{"docType":"SUPPLY","totals":{"EUR":900000}}`)
+}
+
+func TestSupply_Audit(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkInvoke(t, stub, [][]byte{[]byte("issue"), []byte("5000"), []byte("USD")})
+	// the debits of the reserve are deltas keyed by TxID
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100", "USD")
+	checkInvoke(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10.50")})
+	checkInvoke(t, stub, [][]byte{[]byte("closeaccount"), []byte("COMPTE_KARINE"), []byte("COMPTE_JYG")})
+
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"},{"currency":"USD","supply":"5000.00","accounts":"5000.00","drift":"0.00"}]}`)
+
+	// moving money to the same account used to credit it with a stale copy
+	checkInvokeFails(t, stub, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_JYG"), []byte("10")})
+
+	// a balance changed outside the chaincode shows up as drift
+	stub.MockTransactionStart("tamper")
+	stub.PutState("COMPTE_JYG", []byte(`{"docType":"ACCOUNT","name":"COMPTE_JYG","balances":{"EUR":100,"USD":10000},"totalsforday":{},"owner":"Org1MSP/jyg"}`))
+	stub.MockTransactionEnd("tamper")
+	checkAudit(t, stub, `{"version":1,"consistent":false,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"898001.00","drift":"-1999.00"},{"currency":"USD","supply":"5000.00","accounts":"5000.00","drift":"0.00"}]}`)
+
+	// only auditors and bank admins can audit
+	setCreator(t, stub, "Org1MSP", "karine")
+	checkInvokeFails(t, stub, [][]byte{[]byte("auditsupply")})
+
+	// the bank cannot overflow its reserve
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkInvokeFails(t, stub, [][]byte{[]byte("issue"), []byte("184467440737095516"), []byte("EUR")})
+}
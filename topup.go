@@ -0,0 +1,368 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// The bank credits an account from its reserve in two ways. The welcome
+// credit is the first move from MPLBANK in a currency, within the opening
+// limit, and can be turned off with setwelcome. A top-up is any later
+// credit, a loan, a refund or a salary: only tellers make them, with a
+// reason, within the topup limits of the account and of the bank for the
+// business day. Every top-up leaves an audit record under the topup~day
+// index, the caps are computed from the records of the day.
+const topupIndex = "topup~day" // business day, TxID
+
+// the welcome policy is kept in world state so it can change without a redeploy
+const welcomeKey = "MPLBANK_WELCOME"
+
+// reasons of a top-up
+const (
+	reasonLoan       = "LOAN"
+	reasonRefund     = "REFUND"
+	reasonSalary     = "SALARY"
+	reasonCorrection = "CORRECTION"
+)
+
+var knownReasons = []string{reasonLoan, reasonRefund, reasonSalary, reasonCorrection}
+
+type welcomePolicy struct {
+	ObjectType string `json:"docType"`
+	Enabled    bool   `json:"enabled"` // the bank credits new wallets once, within the opening limit
+}
+
+type topup struct {
+	ObjectType  string `json:"docType"`
+	TxID        string `json:"txid"`
+	Account     string `json:"account"`
+	Currency    string `json:"currency"`
+	Amount      uint64 `json:"amount"` // minor units
+	Reason      string `json:"reason"`
+	Memo        string `json:"memo,omitempty"`
+	Teller      string `json:"teller"` // MSP ID and common name of the caller
+	BusinessDay string `json:"businessday"`
+	Timestamp   string `json:"timestamp"` // transaction timestamp, RFC 3339
+}
+
+// topupResponse describes a top-up in the result of gettopups
+type topupResponse struct {
+	TxID      string `json:"txid"`
+	Account   string `json:"account"`
+	Currency  string `json:"currency"`
+	Amount    string `json:"amount"`
+	Reason    string `json:"reason"`
+	Memo      string `json:"memo,omitempty"`
+	Teller    string `json:"teller"`
+	Timestamp string `json:"timestamp"`
+}
+
+// topupsResponse is the result of gettopups
+type topupsResponse struct {
+	Version     int             `json:"version"`
+	BusinessDay string          `json:"businessday"`
+	Topups      []topupResponse `json:"topups"`
+	Fetched     int             `json:"fetched"`            // number of entries in Topups
+	Bookmark    string          `json:"bookmark,omitempty"` // start of the next page, empty on the last one
+}
+
+func getWelcomePolicy(stub shim.ChaincodeStubInterface) (*welcomePolicy, error) {
+	policybytes, err := stub.GetState(welcomeKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for %s", welcomeKey)
+	}
+	policy := &welcomePolicy{ObjectType: "WELCOME", Enabled: true}
+	if policybytes == nil {
+		return policy, nil
+	}
+	err = json.Unmarshal(policybytes, policy)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of: %s", welcomeKey)
+	}
+	return policy, nil
+}
+
+// checkWelcome rejects a welcome credit when the policy turned it off
+func checkWelcome(stub shim.ChaincodeStubInterface, name string) error {
+	policy, err := getWelcomePolicy(stub)
+	if err != nil {
+		return err
+	}
+	if !policy.Enabled {
+		return newError(codeWelcomeDisabled, details{"account": name})
+	}
+	return nil
+}
+
+func isKnownReason(reason string) bool {
+	for _, r := range knownReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// topupsOfDay reads the audit records of the top-ups of a business day
+func topupsOfDay(stub shim.ChaincodeStubInterface, day string) ([]*topup, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(topupIndex, []string{day})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	topups := []*topup{}
+	for resultsIterator.HasNext() {
+		kv, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		tu := &topup{}
+		err = json.Unmarshal(kv.Value, tu)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode JSON of: %s", kv.Key)
+		}
+		topups = append(topups, tu)
+	}
+	return topups, nil
+}
+
+func putTopup(stub shim.ChaincodeStubInterface, tu *topup) error {
+	key, err := stub.CreateCompositeKey(topupIndex, []string{tu.BusinessDay, tu.TxID})
+	if err != nil {
+		return err
+	}
+	topupbytes, err := json.Marshal(tu)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, topupbytes)
+}
+
+// topup credits an existing account from the reserve, args are the account,
+// the amount, a reason among LOAN, REFUND, SALARY and CORRECTION, an optional
+// currency and memo
+func (t *SimpleChaincode) topup(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	reason, memo := args[2], optional(args, 4)
+	if !isKnownReason(reason) {
+		return errorResponse(stub, newError(codeInvalidReason, details{"reason": reason, "reasons": strings.Join(knownReasons, ", ")}))
+	}
+	err := checkMemo(memo)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currency, err := currencies.resolve(optional(args, 3))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	digits := currencies.digits(currency)
+	X, err := parseAmount(args[1], digits)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if X == 0 {
+		return errorResponse(stub, newError(codeInvalidAmount, details{"amount": args[1]}))
+	}
+
+	if args[0] == "MPLBANK" {
+		return errorResponse(stub, newError(codeSameAccount, details{"account": args[0]}))
+	}
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if acc.isClosed() {
+		return errorResponse(stub, newError(codeAccountClosed, details{"account": acc.Name}))
+	}
+
+	today, err := currentBusinessDay(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	// the caps hold the top-ups of the day, this one included
+	limits, err := getLimits(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	topups, err := topupsOfDay(stub, today)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	accountTotal, bankTotal := X, X
+	for _, tu := range topups {
+		if tu.Currency != currency {
+			continue
+		}
+		bankTotal, err = addAmount(bankTotal, tu.Amount)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		if tu.Account == acc.Name {
+			accountTotal, err = addAmount(accountTotal, tu.Amount)
+			if err != nil {
+				return errorResponse(stub, err)
+			}
+		}
+	}
+	if limit := limits.topupLimit(acc, currency, digits); accountTotal > limit {
+		return errorResponse(stub, newError(codeTopupLimitExceeded, details{"account": acc.Name, "amount": args[1], "limit": formatAmount(limit, digits), "currency": currency}))
+	}
+	if limit := limits.bankTopupLimit(currency, digits); bankTotal > limit {
+		return errorResponse(stub, newError(codeBankTopupExceeded, details{"amount": args[1], "limit": formatAmount(limit, digits), "currency": currency}))
+	}
+
+	bank, err := getAccount(stub, "MPLBANK")
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	bankBalance, err := debitReserve(stub, bank, currency, X, args[1])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	balance, err := addAmount(acc.Balances[currency], X)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	acc.Balances[currency] = balance
+	if acc.Deltas {
+		err = putDelta(stub, acc.Name, map[string]uint64{currency: X}, nil)
+	} else {
+		err = putAccount(stub, acc)
+	}
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	tr := newTransfer(stub, bank.Name, acc.Name, currency, X, today, now, caller)
+	tr.Memo = memo
+	err = putTransfer(stub, tr, now)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = putTopup(stub, &topup{
+		ObjectType:  "TOPUP",
+		TxID:        stub.GetTxID(),
+		Account:     acc.Name,
+		Currency:    currency,
+		Amount:      X,
+		Reason:      reason,
+		Memo:        memo,
+		Teller:      caller.key(),
+		BusinessDay: today,
+		Timestamp:   now.Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	err = setEvent(stub, eventTransfer, transferEvent{
+		eventHeader:   newEventHeader(stub, eventTransfer, today, caller),
+		Debit:         bank.Name,
+		Credit:        acc.Name,
+		Currency:      currency,
+		Amount:        newAmountValue(X, digits),
+		DebitBalance:  newAmountValue(bankBalance, digits),
+		CreditBalance: newAmountValue(balance, digits),
+		Memo:          memo,
+	})
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
+
+// gettopups lists the top-ups of a business day, args are the day and an
+// optional page size and bookmark
+func (t *SimpleChaincode) gettopups(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	pageSize, err := parsePageSize(optional(args, 1))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	p, err := compositePage(stub, topupIndex, []string{args[0]}, pageSize, optional(args, 2))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	resp := &topupsResponse{Version: responseVersion, BusinessDay: args[0], Topups: []topupResponse{}, Fetched: len(p.Records), Bookmark: p.Bookmark}
+	for _, kv := range p.Records {
+		tu := &topup{}
+		err = json.Unmarshal(kv.Value, tu)
+		if err != nil {
+			return errorResponse(stub, fmt.Errorf("Failed to decode JSON of: %s", kv.Key))
+		}
+		resp.Topups = append(resp.Topups, topupResponse{
+			TxID:      tu.TxID,
+			Account:   tu.Account,
+			Currency:  tu.Currency,
+			Amount:    currencies.format(tu.Amount, tu.Currency),
+			Reason:    tu.Reason,
+			Memo:      tu.Memo,
+			Teller:    tu.Teller,
+			Timestamp: tu.Timestamp,
+		})
+	}
+	return respond(stub, resp)
+}
+
+// setwelcome turns the welcome credit of the bank on or off, args are true or false
+func (t *SimpleChaincode) setwelcome(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	enabled, err := strconv.ParseBool(args[0])
+	if err != nil {
+		return errorResponse(stub, newError(codeInvalidArgument, details{"function": "setwelcome", "argument": "enabled", "type": "boolean"}))
+	}
+	policy, err := getWelcomePolicy(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	policy.Enabled = enabled
+
+	policybytes, err := json.Marshal(policy)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	err = stub.PutState(welcomeKey, policybytes)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	return shim.Success(nil)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkTopup(t *testing.T, stub *shim.MockStub, txid string, args ...string) {
+	bargs := [][]byte{[]byte("topup")}
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	res := stub.MockInvoke(txid, bargs)
+	if res.Status != shim.OK {
+		fmt.Println("topup", args, "failed", res.Message)
+		t.FailNow()
+	}
+}
+
+func TestTopup_Teller(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("default"), []byte(""), []byte("topup"), []byte("300")})
+	checkInvoke(t, stub, [][]byte{[]byte("setlimit"), []byte("bank"), []byte(""), []byte("topup"), []byte("500")})
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte("fabien"), []byte("teller")})
+
+	// the bank operator is not a teller
+	checkError(t, stub, codeAccessDenied, [][]byte{[]byte("topup"), []byte("COMPTE_KARINE"), []byte("10"), []byte(reasonLoan)})
+
+	setCreator(t, stub, "Org1MSP", "fabien")
+	checkTopup(t, stub, "u1", "COMPTE_KARINE", "250", reasonSalary, "", "Salaire juin")
+	var event transferEvent
+	checkEvent(t, stub, eventTransfer, &event)
+	if event.Debit != "MPLBANK" || event.Credit != "COMPTE_KARINE" || event.CreditBalance.Amount != "350.00" || event.Memo != "Salaire juin" || event.Requester != "fabien" {
+		fmt.Println("unexpected Transfer", event)
+		t.FailNow()
+	}
+	checkBalance(t, stub, "COMPTE_KARINE", "350.00")
+	checkBalance(t, stub, "MPLBANK", "897650.00")
+
+	// the caps hold the top-ups of the day, per account and for the bank
+	checkError(t, stub, codeTopupLimitExceeded, [][]byte{[]byte("topup"), []byte("COMPTE_KARINE"), []byte("50.01"), []byte(reasonLoan)})
+	checkTopup(t, stub, "u2", "COMPTE_JYG", "200", reasonRefund)
+	checkError(t, stub, codeBankTopupExceeded, [][]byte{[]byte("topup"), []byte("COMPTE_JYG"), []byte("50.01"), []byte(reasonLoan)})
+	checkTopup(t, stub, "u3", "COMPTE_KARINE", "50", reasonCorrection)
+
+	for code, args := range map[string][]string{
+		codeInvalidReason:   {"COMPTE_JYG", "10", "gift"},
+		codeInvalidAmount:   {"COMPTE_JYG", "0", reasonLoan},
+		codeSameAccount:     {"MPLBANK", "10", reasonLoan},
+		codeAccountNotFound: {"COMPTE_INCONNU", "10", reasonLoan},
+		codeUnknownCurrency: {"COMPTE_JYG", "10", reasonLoan, "USD"},
+	} {
+		bargs := [][]byte{[]byte("topup")}
+		for _, a := range args {
+			bargs = append(bargs, []byte(a))
+		}
+		checkError(t, stub, code, bargs)
+	}
+
+	// the audit records of the day, in TxID order
+	var day string
+	for key, value := range stub.State {
+		var tu topup
+		if len(key) > 0 && key[0] == 0 && json.Unmarshal(value, &tu) == nil && tu.ObjectType == "TOPUP" {
+			day = tu.BusinessDay
+		}
+	}
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkAudit(t, stub, `{"version":1,"consistent":true,"currencies":[{"currency":"EUR","supply":"900000.00","accounts":"900000.00","drift":"0.00"}]}`)
+	res := stub.MockInvoke("1", [][]byte{[]byte("gettopups"), []byte(day), []byte("2")})
+	var resp topupsResponse
+	if err := json.Unmarshal(res.Payload, &resp); err != nil || resp.Fetched != 2 || resp.Bookmark == "" ||
+		resp.Topups[0].TxID != "u1" || resp.Topups[0].Reason != reasonSalary || resp.Topups[0].Amount != "250.00" ||
+		resp.Topups[0].Teller != "Org1MSP/fabien" || resp.Topups[1].Account != "COMPTE_JYG" {
+		fmt.Println("unexpected gettopups", string(res.Payload), res.Message)
+		t.FailNow()
+	}
+	res = stub.MockInvoke("1", [][]byte{[]byte("gettopups"), []byte(day), []byte("2"), []byte(resp.Bookmark)})
+	var last topupsResponse
+	if err := json.Unmarshal(res.Payload, &last); err != nil || last.Fetched != 1 || last.Bookmark != "" || last.Topups[0].TxID != "u3" {
+		fmt.Println("unexpected second page of gettopups", string(res.Payload), res.Message)
+		t.FailNow()
+	}
+}
+
+func TestTopup_Welcome(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+
+	checkError(t, stub, codeInvalidArgument, [][]byte{[]byte("setwelcome"), []byte("maybe")})
+	checkInvoke(t, stub, [][]byte{[]byte("setwelcome"), []byte("false")})
+	checkError(t, stub, codeWelcomeDisabled, [][]byte{[]byte("move"), []byte("MPLBANK"), []byte("COMPTE_KARINE"), []byte("100")})
+	checkError(t, stub, codeWelcomeDisabled, [][]byte{[]byte("openaccount"), []byte("COMPTE_LUC"), []byte("Org2MSP"), []byte("luc"), []byte(productCurrent), []byte("100")})
+
+	// accounts still open empty, and are funded by a teller
+	checkOpen(t, stub, "o1", "COMPTE_LUC", "Org2MSP", "luc", productCurrent)
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte("fabien"), []byte("teller")})
+	setCreator(t, stub, "Org1MSP", "fabien")
+	checkTopup(t, stub, "u1", "COMPTE_LUC", "100", reasonLoan)
+	checkBalance(t, stub, "COMPTE_LUC", "100.00")
+
+	setCreator(t, stub, "Org1MSP", "jyg")
+	checkInvoke(t, stub, [][]byte{[]byte("setwelcome"), []byte("true")})
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+	checkBalance(t, stub, "COMPTE_KARINE", "100.00")
+}
@@ -0,0 +1,257 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Every move writes a transfer record under the transfer index, keyed by
+// its TxID, and is never changed afterwards. The account~transfer index
+// lists the transfers of an account, debit or credit, in time order.
+const (
+	transferIndex        = "transfer"         // TxID
+	accountTransferIndex = "account~transfer" // account, timestamp, TxID
+)
+
+// timestamps in the account~transfer index have a fixed width so keys sort in time order
+const transferTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// A reference is the end-to-end identifier of the payer, e.g. an invoice
+// number. The reference~day index holds the TxID of the move that used it,
+// per debit account and business day, to reject a replayed payment.
+const referenceIndex = "reference~day" // debit account, business day, reference
+
+// longest memo, in characters
+const maxMemoLength = 140
+
+// references follow the ISO 20022 end-to-end identifier: at most 35 characters of the SEPA set
+const maxReferenceLength = 35
+
+var referenceChars = regexp.MustCompile(`^[A-Za-z0-9/?:().,'+ -]*$`)
+
+type transfer struct {
+	ObjectType  string `json:"docType"`
+	TxID        string `json:"txid"`
+	Debit       string `json:"debit"`
+	Credit      string `json:"credit"`
+	Currency    string `json:"currency"`
+	Amount      uint64 `json:"amount"` // minor units
+	BusinessDay string `json:"businessday"`
+	Timestamp   string `json:"timestamp"` // transaction timestamp, RFC 3339
+	Requester   string `json:"requester"` // MSP ID and common name of the caller
+	Memo        string `json:"memo,omitempty"`
+	Reference   string `json:"reference,omitempty"`
+}
+
+// checkMemo accepts printable text, an empty memo is no memo
+func checkMemo(memo string) error {
+	invalid := newError(codeInvalidMemo, details{"max": strconv.Itoa(maxMemoLength)})
+	if !utf8.ValidString(memo) || utf8.RuneCountInString(memo) > maxMemoLength {
+		return invalid
+	}
+	for _, r := range memo {
+		if !unicode.IsPrint(r) {
+			return invalid
+		}
+	}
+	return nil
+}
+
+// checkReference validates the characters of a reference, an empty reference is no reference
+func checkReference(reference string) error {
+	if len(reference) > maxReferenceLength || !referenceChars.MatchString(reference) {
+		return newError(codeInvalidReference, details{"reference": reference, "max": strconv.Itoa(maxReferenceLength)})
+	}
+	return nil
+}
+
+// checkReplay rejects a reference the debit account already used on the business day
+func checkReplay(stub shim.ChaincodeStubInterface, debit string, day string, reference string) error {
+	key, err := stub.CreateCompositeKey(referenceIndex, []string{debit, day, reference})
+	if err != nil {
+		return err
+	}
+	txid, err := stub.GetState(key)
+	if err != nil {
+		return fmt.Errorf("Failed to get state for reference %s", reference)
+	}
+	if txid != nil {
+		return newError(codeDuplicateReference, details{"account": debit, "reference": reference, "businessday": day, "txid": string(txid)})
+	}
+	return nil
+}
+
+// newTransfer describes the move of the current transaction
+func newTransfer(stub shim.ChaincodeStubInterface, debit string, credit string, currency string, amount uint64, day string, ts time.Time, caller *identity) *transfer {
+	return &transfer{
+		ObjectType:  "TRANSFER",
+		TxID:        stub.GetTxID(),
+		Debit:       debit,
+		Credit:      credit,
+		Currency:    currency,
+		Amount:      amount,
+		BusinessDay: day,
+		Timestamp:   ts.Format(time.RFC3339Nano),
+		Requester:   caller.key(),
+	}
+}
+
+// putTransfer writes the record, its index entry for both accounts and its reference
+func putTransfer(stub shim.ChaincodeStubInterface, tr *transfer, ts time.Time) error {
+	key, err := stub.CreateCompositeKey(transferIndex, []string{tr.TxID})
+	if err != nil {
+		return err
+	}
+	transferbytes, err := json.Marshal(tr)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(key, transferbytes)
+	if err != nil {
+		return err
+	}
+
+	if tr.Reference != "" {
+		referenceKey, err := stub.CreateCompositeKey(referenceIndex, []string{tr.Debit, tr.BusinessDay, tr.Reference})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(referenceKey, []byte(tr.TxID))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, name := range []string{tr.Debit, tr.Credit} {
+		indexKey, err := stub.CreateCompositeKey(accountTransferIndex, []string{name, ts.UTC().Format(transferTimeLayout), tr.TxID})
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(indexKey, []byte{0x00})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getTransfer(stub shim.ChaincodeStubInterface, txid string) (*transfer, error) {
+	key, err := stub.CreateCompositeKey(transferIndex, []string{txid})
+	if err != nil {
+		return nil, err
+	}
+	transferbytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get state for transfer %s", txid)
+	}
+	if transferbytes == nil {
+		return nil, newError(codeTransferNotFound, details{"txid": txid})
+	}
+	tr := &transfer{}
+	err = json.Unmarshal(transferbytes, tr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode JSON of transfer %s", txid)
+	}
+	return tr, nil
+}
+
+// gettransfer returns a transfer, args are the TxID of the move and an optional format
+func (t *SimpleChaincode) gettransfer(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	format, err := parseFormat(optional(args, 1))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	tr, err := getTransfer(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	// a transfer of accounts the caller cannot read is not disclosed
+	readable := false
+	for _, name := range []string{tr.Debit, tr.Credit} {
+		acc, err := getAccount(stub, name)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		readable = readable || caller.reads(acc)
+	}
+	if !readable {
+		return errorResponse(stub, newError(codeTransferNotFound, details{"txid": args[0]}))
+	}
+	return respond(stub, newTransferResponse(tr, currencies, format))
+}
+
+// gettransfersforaccount lists the transfers debiting or crediting an
+// account, oldest first, args are the account and an optional format, page
+// size and bookmark
+func (t *SimpleChaincode) gettransfersforaccount(stub shim.ChaincodeStubInterface, args []string, caller *identity) pb.Response {
+
+	format, err := parseFormat(optional(args, 1))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	pageSize, err := parsePageSize(optional(args, 2))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	currencies, err := getCurrencies(stub)
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+	if !caller.reads(acc) {
+		return errorResponse(stub, newError(codeNotOwner, details{"account": acc.Name}))
+	}
+
+	p, err := compositePage(stub, accountTransferIndex, []string{acc.Name}, pageSize, optional(args, 3))
+	if err != nil {
+		return errorResponse(stub, err)
+	}
+
+	resp := &transfersResponse{Version: responseVersion, Name: acc.Name, Transfers: []*transferResponse{}, Fetched: len(p.Records), Bookmark: p.Bookmark}
+	for _, kv := range p.Records {
+		_, compositeKeyParts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		tr, err := getTransfer(stub, compositeKeyParts[2])
+		if err != nil {
+			return errorResponse(stub, err)
+		}
+		resp.Transfers = append(resp.Transfers, newTransferResponse(tr, currencies, format))
+	}
+	return respond(stub, resp)
+}
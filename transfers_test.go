@@ -0,0 +1,181 @@
+/*
+Copyright IBM Corp. 2017 All Rights Reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+		 http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// transfers are keyed by TxID, the moves of these tests need their own
+func checkMove(t *testing.T, stub *shim.MockStub, txid string, args ...string) {
+	bargs := [][]byte{[]byte("move")}
+	for _, a := range args {
+		bargs = append(bargs, []byte(a))
+	}
+	res := stub.MockInvoke(txid, bargs)
+	if res.Status != shim.OK {
+		fmt.Println("move", args, "failed", res.Message)
+		t.FailNow()
+	}
+}
+
+// listTransfers follows the bookmarks of gettransfersforaccount and returns the TxIDs of every page
+func listTransfers(t *testing.T, stub *shim.MockStub, name string, pageSize string) [][]string {
+	pages := [][]string{}
+	bookmark := ""
+	for len(pages) < 10 {
+		res := stub.MockInvoke("1", [][]byte{[]byte("gettransfersforaccount"), []byte(name), []byte(""), []byte(pageSize), []byte(bookmark)})
+		var resp transfersResponse
+		if err := json.Unmarshal(res.Payload, &resp); err != nil {
+			fmt.Println("gettransfersforaccount returned", res.Message)
+			t.FailNow()
+		}
+		txids := []string{}
+		for _, tr := range resp.Transfers {
+			txids = append(txids, tr.TxID)
+		}
+		pages = append(pages, txids)
+		if resp.Bookmark == "" {
+			break
+		}
+		bookmark = resp.Bookmark
+	}
+	return pages
+}
+
+func TestTransfers_Records(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+	checkMove(t, stub, "t3", "COMPTE_JYG", "COMPTE_KARINE", "10.50", "", "invoice 42")
+
+	res := stub.MockInvoke("1", [][]byte{[]byte("gettransfer"), []byte("t3"), []byte("verbose")})
+	var tr transferResponse
+	if err := json.Unmarshal(res.Payload, &tr); err != nil {
+		fmt.Println("gettransfer failed", res.Message)
+		t.FailNow()
+	}
+	ts, err := time.Parse(time.RFC3339Nano, tr.Timestamp)
+	if err != nil || ts.IsZero() || tr.Debit != "COMPTE_JYG" || tr.Credit != "COMPTE_KARINE" || tr.Currency != "EUR" ||
+		tr.Amount != "10.50" || tr.Minor != "1050" || tr.BusinessDay == "" || tr.Requester != "Org1MSP/jyg" || tr.Memo != "invoice 42" {
+		fmt.Println("unexpected transfer", string(res.Payload))
+		t.FailNow()
+	}
+
+	// a failed move leaves no record
+	res = stub.MockInvoke("t4", [][]byte{[]byte("move"), []byte("COMPTE_KARINE"), []byte("COMPTE_JYG"), []byte("500")})
+	if res.Status == shim.OK {
+		fmt.Println("move without funds succeeded")
+		t.FailNow()
+	}
+	checkError(t, stub, codeTransferNotFound, [][]byte{[]byte("gettransfer"), []byte("t4")})
+
+	for name, expected := range map[string]string{
+		"COMPTE_JYG":    "[[t1 t3]]",
+		"COMPTE_KARINE": "[[t2 t3]]",
+		"MPLBANK":       "[[t1 t2]]",
+	} {
+		if pages := listTransfers(t, stub, name, ""); fmt.Sprint(pages) != expected {
+			fmt.Println("transfers of", name, pages, "instead of", expected)
+			t.FailNow()
+		}
+	}
+	if pages := listTransfers(t, stub, "COMPTE_KARINE", "1"); fmt.Sprint(pages) != "[[t2] [t3]]" {
+		fmt.Println("unexpected pages of transfers", pages)
+		t.FailNow()
+	}
+
+	checkError(t, stub, codeAccountNotFound, [][]byte{[]byte("gettransfersforaccount"), []byte("COMPTE_INCONNU")})
+
+	// customers only read the transfers of their accounts
+	checkOpen(t, stub, "o1", "COMPTE_LUC", "Org1MSP", "luc", productCurrent)
+	checkInvoke(t, stub, [][]byte{[]byte("grantrole"), []byte("Org1MSP"), []byte("luc"), []byte("customer")})
+	checkMove(t, stub, "t5", "COMPTE_JYG", "COMPTE_LUC", "5")
+	setCreator(t, stub, "Org1MSP", "luc")
+	res = stub.MockInvoke("1", [][]byte{[]byte("gettransfer"), []byte("t5")})
+	if err := json.Unmarshal(res.Payload, &tr); err != nil || tr.TxID != "t5" {
+		fmt.Println("gettransfer of the credit account failed", res.Message)
+		t.FailNow()
+	}
+	if pages := listTransfers(t, stub, "COMPTE_LUC", ""); fmt.Sprint(pages) != "[[t5]]" {
+		fmt.Println("unexpected transfers of COMPTE_LUC", pages)
+		t.FailNow()
+	}
+	checkError(t, stub, codeTransferNotFound, [][]byte{[]byte("gettransfer"), []byte("t3")})
+	checkError(t, stub, codeNotOwner, [][]byte{[]byte("gettransfersforaccount"), []byte("COMPTE_JYG")})
+}
+
+func TestTransfers_Reference(t *testing.T) {
+	scc := new(SimpleChaincode)
+	stub := shim.NewMockStub("ex02", scc)
+	setCreator(t, stub, "Org1MSP", "jyg")
+
+	checkInit(t, stub, [][]byte{[]byte("init"), []byte("900000")})
+	openAccounts(t, stub, "COMPTE_JYG", "COMPTE_KARINE")
+	checkMove(t, stub, "t1", "MPLBANK", "COMPTE_JYG", "2000")
+	checkMove(t, stub, "t2", "MPLBANK", "COMPTE_KARINE", "100")
+
+	checkMove(t, stub, "t3", "COMPTE_JYG", "COMPTE_KARINE", "10", "", "Facture d'électricité", "INV-2017/42")
+	var event transferEvent
+	checkEvent(t, stub, eventTransfer, &event)
+	if event.Memo != "Facture d'électricité" || event.Reference != "INV-2017/42" {
+		fmt.Println("unexpected Transfer", event)
+		t.FailNow()
+	}
+	res := stub.MockInvoke("1", [][]byte{[]byte("gettransfer"), []byte("t3")})
+	var tr transferResponse
+	if err := json.Unmarshal(res.Payload, &tr); err != nil || tr.Memo != "Facture d'électricité" || tr.Reference != "INV-2017/42" {
+		fmt.Println("unexpected transfer", string(res.Payload))
+		t.FailNow()
+	}
+
+	// the same reference from the same account on the same day is a replay
+	e := checkError(t, stub, codeDuplicateReference, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10"), []byte(""), []byte(""), []byte("INV-2017/42")})
+	if e.Fields["txid"] != "t3" {
+		fmt.Println("duplicate reference does not name the first transaction", e)
+		t.FailNow()
+	}
+	// another payer, or another day, can use it
+	checkMove(t, stub, "t4", "COMPTE_KARINE", "COMPTE_JYG", "10", "", "", "INV-2017/42")
+	checkInvoke(t, stub, [][]byte{[]byte("changeday")})
+	checkMove(t, stub, "t5", "COMPTE_JYG", "COMPTE_KARINE", "10", "", "", "INV-2017/42")
+
+	long := ""
+	for len(long) < maxReferenceLength+1 {
+		long += "A"
+	}
+	for _, reference := range []string{"INV_42", "INV\n42", long} {
+		checkError(t, stub, codeInvalidReference, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10"), []byte(""), []byte(""), []byte(reference)})
+	}
+	long = ""
+	for len([]rune(long)) < maxMemoLength {
+		long += "é"
+	}
+	checkMove(t, stub, "t6", "COMPTE_JYG", "COMPTE_KARINE", "1", "", long)
+	for _, memo := range []string{long + "e", "ligne 1\nligne 2", "\xff"} {
+		checkError(t, stub, codeInvalidMemo, [][]byte{[]byte("move"), []byte("COMPTE_JYG"), []byte("COMPTE_KARINE"), []byte("10"), []byte(""), []byte(memo)})
+	}
+}